@@ -0,0 +1,155 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// isArchiveFile reports whether a file is a zip or tar bundle of term files
+func isArchiveFile(name string) bool {
+	return isZipFile(name) || isTarFile(name)
+}
+
+func isZipFile(name string) bool {
+	return hasAnySuffix(name, ".zip")
+}
+
+func isTarFile(name string) bool {
+	return hasAnySuffix(name, ".tar", ".tar.gz", ".tgz")
+}
+
+// isGzipFile reports whether a file is a single gzipped term document, such as .json.gz
+func isGzipFile(name string) bool {
+	return hasAnySuffix(name, ".gz") && isTermFile(strings.TrimSuffix(strings.ToLower(name), ".gz"))
+}
+
+// openGzipFile opens a gzip-compressed file for reading its contents
+func openGzipFile(path string) (io.ReadCloser, error) {
+	return openGzipMember(func() (io.ReadCloser, error) { return os.Open(path) })
+}
+
+// memberLabel names an archive member in the ingestion report
+func memberLabel(archive, member string) string {
+	return archive + "!" + member
+}
+
+// isTermMember reports whether an archive member should be read: term files
+// only, skipping macOS resource forks and other hidden files
+func isTermMember(name string) bool {
+	base := path.Base(name)
+	return !strings.HasPrefix(base, ".") && !strings.HasPrefix(name, "__MACOSX/") && (isTermFile(name) || isGzipFile(name))
+}
+
+// readArchive reads every JSON, JSON Lines or gzipped member of a zip or tar
+// archive, with the same format detection used for plain files
+func readArchive(archivePath string, set *termSet, report *ingestReport) {
+	var err error
+	if isZipFile(archivePath) {
+		err = readZipArchive(archivePath, set, report)
+	} else {
+		err = readTarArchive(archivePath, set, report)
+	}
+	if err != nil {
+		report.record(archivePath, "", 0, fmt.Errorf("reading archive: %w", err))
+	}
+}
+
+// readZipArchive reads the term members of a zip archive
+func readZipArchive(archivePath string, set *termSet, report *ingestReport) error {
+	zr, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	for _, member := range zr.File {
+		if member.FileInfo().IsDir() || !isTermMember(member.Name) {
+			continue
+		}
+
+		member := member
+		open := member.Open
+		name := member.Name
+		if isGzipFile(name) {
+			open = func() (io.ReadCloser, error) { return openGzipMember(member.Open) }
+			name = strings.TrimSuffix(name, path.Ext(name))
+		}
+		readTermDocument(memberLabel(archivePath, member.Name), name, "", open, set, report)
+	}
+
+	return nil
+}
+
+// readTarArchive reads the term members of a tar or tar.gz archive. Tar
+// members can only be read once, so JSON errors are reported by byte offset
+// without a line and column.
+func readTarArchive(archivePath string, set *termSet, report *ingestReport) error {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if hasAnySuffix(archivePath, ".gz", ".tgz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg || !isTermMember(hdr.Name) {
+			continue
+		}
+
+		label := memberLabel(archivePath, hdr.Name)
+		var member io.Reader = tr
+		name := hdr.Name
+		if isGzipFile(name) {
+			gz, err := gzip.NewReader(tr)
+			if err != nil {
+				report.record(label, "", 0, err)
+				continue
+			}
+			member = gz
+			name = strings.TrimSuffix(name, path.Ext(name))
+		}
+
+		format, n, err := readTermStream(name, "", nil, member, set)
+		report.record(label, format, n, err)
+	}
+}
+
+// openGzipMember wraps a file or archive member opener with gzip decompression
+func openGzipMember(open func() (io.ReadCloser, error)) (io.ReadCloser, error) {
+	rc, err := open()
+	if err != nil {
+		return nil, err
+	}
+	gz, err := gzip.NewReader(rc)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{gz, rc}, nil
+}
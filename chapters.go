@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Chapter groupings for -chapters; a number puts that many entries in each file
+const (
+	chaptersByLetter = "letter" // one file per root letter
+	chaptersByTerm   = "term"   // one file per term
+)
+
+// unsortedChapterSize is the number of entries per file that replaces letter
+// chapters when terms are not in Tibetan order, where a root letter does not
+// come in one run
+const unsortedChapterSize = 500
+
+// validateChapterGrouping checks a -chapters value
+func validateChapterGrouping(grouping string) error {
+	switch grouping {
+	case chaptersByLetter, chaptersByTerm:
+		return nil
+	}
+	if n, err := strconv.Atoi(grouping); err == nil && n > 0 {
+		return nil
+	}
+	return fmt.Errorf("unknown chapter grouping %q (want %s, %s or a number of entries per file)", grouping, chaptersByLetter, chaptersByTerm)
+}
+
+// termChapter is one chapter file of the Tibetan-English volume. Each of its
+// terms is an entry anchored as termN, N counting terms through the book.
+type termChapter struct {
+	Letter string // root letter shared by the terms, when grouped by letter
+	File   string
+	First  int // number of the first term
+	Terms  []TermData
+}
+
+// title returns the chapter's document title
+func (c termChapter) title() string {
+	switch {
+	case c.Letter != "":
+		return c.Letter
+	case len(c.Terms) == 1:
+		return termLabel(c.Terms[0])
+	}
+	return fmt.Sprintf("Terms %d-%d", c.First, c.First+len(c.Terms)-1)
+}
+
+// termAnchor returns the id of term n's entry
+func termAnchor(n int) string {
+	return fmt.Sprintf("term%d", n)
+}
+
+// termLabel returns the headword shown for a term in tables of contents:
+// its Tibetan script, or its Wylie if it has none
+func termLabel(t TermData) string {
+	if t.SearchTerm != "" {
+		return t.SearchTerm
+	}
+	return t.SearchTermWylie
+}
+
+// groupTermChapters splits sorted terms into chapter files. By letter, a new
+// file starts whenever the root letter changes, so terms in another order
+// than Tibetan get a file per run of the same letter.
+func groupTermChapters(terms []TermData, grouping string) []termChapter {
+	size, _ := strconv.Atoi(grouping)
+	if grouping == chaptersByTerm {
+		size = 1
+	}
+
+	var chapters []termChapter
+	for i, term := range terms {
+		letter := ""
+		if size == 0 {
+			letter = termRootLetter(term)
+		}
+		n := len(chapters)
+		if n == 0 || (size > 0 && len(chapters[n-1].Terms) == size) || (size == 0 && chapters[n-1].Letter != letter) {
+			chapters = append(chapters, termChapter{
+				Letter: letter,
+				File:   fmt.Sprintf("chapter%d.xhtml", n+1),
+				First:  i + 1,
+			})
+			n++
+		}
+		chapters[n-1].Terms = append(chapters[n-1].Terms, term)
+	}
+	return chapters
+}
+
+// termLinks returns the link to each term's entry, in term order
+func termLinks(chapters []termChapter) []string {
+	var links []string
+	for _, chapter := range chapters {
+		for i := range chapter.Terms {
+			links = append(links, chapter.File+"#"+termAnchor(chapter.First+i))
+		}
+	}
+	return links
+}
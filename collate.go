@@ -0,0 +1,276 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Term orders for -sort
+const (
+	orderTibetan     = "tibetan"     // Tibetan dictionary order
+	orderWylie       = "wylie"       // Wylie alphabetical, ignoring a leading ' and case
+	orderEnglish     = "english"     // by the first English gloss
+	orderInput       = "input"       // as found in the inputs, for curated lists
+	orderDefinitions = "definitions" // most definitions first
+)
+
+// validateSortOrder checks a -sort value
+func validateSortOrder(order string) error {
+	switch order {
+	case orderTibetan, orderWylie, orderEnglish, orderInput, orderDefinitions:
+		return nil
+	}
+	return fmt.Errorf("unknown sort order %q (want %s, %s, %s, %s or %s)", order, orderTibetan, orderWylie, orderEnglish, orderInput, orderDefinitions)
+}
+
+// Tibetan dictionary order.
+//
+// Headwords are compared syllable by syllable. Each syllable is ordered by
+// its root letter in ka-kha-ga order first; prefixes and superscripts only
+// separate syllables with the same root, so bka' files under ka, after
+// kya but before kha. Then come the subscripts, the vowel and the
+// letters after the root (suffixes, a final 'i, Sanskrit stacks). A headword
+// sorts before its own compounds (ka, ka ba, kag).
+
+// tibetanAlphabet lists the letters in collation order. Sanskrit letters
+// follow the Tibetan letter they are written with.
+var tibetanAlphabet = []string{
+	"k", "kSh", "kh", "g", "gh", "ng", "c", "ch", "j", "ny",
+	"t", "T", "th", "Th", "d", "dh", "D", "Dh", "n", "N",
+	"p", "ph", "f", "b", "bh", "v", "m",
+	"ts", "tsh", "dz", "dzh", "w", "W", "zh", "z", "'", "y", "Y",
+	"r", "R", "l", "sh", "Sh", "s", "h", "a",
+}
+
+// tibetanVowelOrder lists the vowels in collation order; "" is the inherent a
+var tibetanVowelOrder = []string{"", "A", "i", "I", "-i", "-I", "u", "U", "r-i", "r-I", "l-i", "l-I", "e", "ai", "o", "au"}
+
+// Orders of the superscripts, prefixes and subscripts; "" means none
+var (
+	tibetanSuperscriptOrder = []string{"", "r", "l", "s"}
+	tibetanPrefixOrder      = []string{"", "g", "d", "b", "m", "'"}
+	tibetanSubscriptOrder   = []string{"", "y", "r", "l", "w"}
+)
+
+// collationRank maps each letter and mark to its position in its order,
+// starting at 1 so that 0 can separate syllables in a sort key
+var collationRank = func() map[string]map[string]byte {
+	ranks := make(map[string]map[string]byte)
+	for name, order := range map[string][]string{
+		"letter":      tibetanAlphabet,
+		"vowel":       tibetanVowelOrder,
+		"superscript": tibetanSuperscriptOrder,
+		"prefix":      tibetanPrefixOrder,
+		"subscript":   tibetanSubscriptOrder,
+	} {
+		ranks[name] = make(map[string]byte, len(order))
+		for i, v := range order {
+			ranks[name][v] = byte(i + 1)
+		}
+	}
+	return ranks
+}()
+
+// rank returns the collation rank of v in the named order. Unknown values sort last.
+func rank(order, v string) byte {
+	if r, ok := collationRank[order][v]; ok {
+		return r
+	}
+	return byte(len(collationRank[order]) + 1)
+}
+
+// tibetanSortKey returns a key that orders Tibetan-script text in dictionary
+// order when compared as a byte string. Text with no Tibetan letters gets a
+// key that sorts after all Tibetan text.
+func tibetanSortKey(s string) string {
+	syllables := tibetanSyllables(s)
+	if len(syllables) == 0 {
+		return "\xff" + strings.ToLower(s)
+	}
+
+	var key []byte
+	for i, syllable := range syllables {
+		if i > 0 {
+			key = append(key, 0)
+		}
+		key = append(key, syllableSortKey(tibetanStacks(syllable))...)
+	}
+	return string(key)
+}
+
+// tibetanSyllables splits text into the runes of each Tibetan syllable,
+// dropping tsheg, shad and anything that is not a letter or mark
+func tibetanSyllables(s string) [][]rune {
+	var syllables [][]rune
+	var current []rune
+	for _, r := range s {
+		_, letter := tibetanLetters[r]
+		_, mark := tibetanMarks[r]
+		if letter || mark || r == '\u0f39' {
+			current = append(current, r)
+			continue
+		}
+		if len(current) > 0 {
+			syllables = append(syllables, current)
+			current = nil
+		}
+	}
+	if len(current) > 0 {
+		syllables = append(syllables, current)
+	}
+	return syllables
+}
+
+// syllableSortKey builds the key of one syllable: root letter, superscript
+// and prefix, subscripts, vowel, then each stack after the root
+func syllableSortKey(stacks []tibetanStack) []byte {
+	root := tibetanRootStack(stacks)
+	st := stacks[root]
+
+	prefix := ""
+	if root > 0 {
+		prefix = strings.Join(stacks[root-1].letters, "")
+	}
+	superscript, rootLetter, subscripts := splitRootStack(st)
+
+	key := []byte{rank("letter", rootLetter), rank("superscript", superscript), rank("prefix", prefix)}
+	for i := 0; i < 2; i++ {
+		sub := ""
+		if i < len(subscripts) {
+			sub = subscripts[i]
+		}
+		key = append(key, rank("subscript", sub))
+	}
+	key = append(key, rank("vowel", st.vowel))
+
+	for _, after := range stacks[root+1:] {
+		for _, l := range after.letters {
+			key = append(key, rank("letter", l))
+		}
+		key = append(key, rank("vowel", after.vowel))
+	}
+	return key
+}
+
+// splitRootStack splits the root stack of a syllable into its superscript,
+// root letter and subscripts. A bare vowel has the root letter a.
+func splitRootStack(st tibetanStack) (string, string, []string) {
+	letters := st.letters
+	superscript := ""
+	if len(letters) >= 2 && takesSuperscript(letters[0], letters[1]) {
+		superscript, letters = letters[0], letters[1:]
+	}
+	if len(letters) == 0 {
+		return superscript, "a", nil
+	}
+	return superscript, letters[0], letters[1:]
+}
+
+// termTibetan returns a term's headword in Tibetan script, converting a
+// Wylie-only headword, or "" if it has none
+func termTibetan(t TermData) string {
+	if isTibetanScript(t.SearchTerm) {
+		return t.SearchTerm
+	}
+	wylie := t.SearchTermWylie
+	if wylie == "" {
+		wylie = t.SearchTerm
+	}
+	if uni, err := ewtsToUnicode(wylie); err == nil && isTibetanScript(uni) {
+		return uni
+	}
+	return ""
+}
+
+// termSortKey returns the Tibetan dictionary-order key of a term's headword,
+// converting a Wylie-only headword to Tibetan script first
+func termSortKey(t TermData) string {
+	if uni := termTibetan(t); uni != "" {
+		return tibetanSortKey(uni)
+	}
+	wylie := t.SearchTermWylie
+	if wylie == "" {
+		wylie = t.SearchTerm
+	}
+	return tibetanSortKey(wylie)
+}
+
+// termRootLetter returns the root letter of the first syllable of a term's
+// headword in Tibetan script, e.g. ཀ for བཀའ་, or "#" if it has none
+func termRootLetter(t TermData) string {
+	syllables := tibetanSyllables(termTibetan(t))
+	if len(syllables) == 0 {
+		return "#"
+	}
+	stacks := tibetanStacks(syllables[0])
+	_, letter, _ := splitRootStack(stacks[tibetanRootStack(stacks)])
+	if uni, err := ewtsToUnicode(letter); err == nil {
+		return uni
+	}
+	return "#"
+}
+
+// sortTerms puts terms in the given order. Input order leaves them as read;
+// the other orders fall back to Tibetan order for equal keys. sources decides
+// which definition is first for the English order.
+func sortTerms(terms []TermData, order string, sources sourceOrder) {
+	if order == orderInput {
+		return
+	}
+
+	keys := make([]string, len(terms))
+	for i, t := range terms {
+		tibetan := termSortKey(t)
+		switch order {
+		case orderWylie:
+			keys[i] = wylieSortKey(t) + "\x00" + tibetan
+		case orderEnglish:
+			keys[i] = englishSortKey(t, sources) + "\x00" + tibetan
+		case orderDefinitions:
+			keys[i] = fmt.Sprintf("%010d", 1<<30-len(t.Definitions)) + tibetan
+		default:
+			keys[i] = tibetan
+		}
+	}
+	sort.Stable(byKey{terms, keys})
+}
+
+// wylieSortKey returns the headword's Wylie, lower-cased and without a
+// leading ' (a-chung), so 'am files under a
+func wylieSortKey(t TermData) string {
+	wylie := t.SearchTermWylie
+	if wylie == "" {
+		wylie, _ = unicodeToHeadword(t.SearchTerm)
+	}
+	return strings.ToLower(strings.TrimLeft(strings.TrimSpace(wylie), "'"))
+}
+
+// englishSortKey returns the first English gloss of a term, from its first
+// source in source order that has one, lower-cased and without leading punctuation. Terms
+// without one sort last.
+func englishSortKey(t TermData, sources sourceOrder) string {
+	for _, source := range sources.sources(t.Definitions) {
+		for _, gloss := range strings.FieldsFunc(englishDefinition(t, source), func(r rune) bool { return r == ';' || r == ',' || r == '\n' }) {
+			gloss = strings.TrimLeftFunc(gloss, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+			if gloss != "" && !isTibetanScript(gloss) {
+				return strings.ToLower(strings.TrimSpace(gloss))
+			}
+		}
+	}
+	return "\xff"
+}
+
+// byKey sorts terms by precomputed keys
+type byKey struct {
+	terms []TermData
+	keys  []string
+}
+
+func (b byKey) Len() int           { return len(b.terms) }
+func (b byKey) Less(i, j int) bool { return b.keys[i] < b.keys[j] }
+func (b byKey) Swap(i, j int) {
+	b.terms[i], b.terms[j] = b.terms[j], b.terms[i]
+	b.keys[i], b.keys[j] = b.keys[j], b.keys[i]
+}
@@ -0,0 +1,60 @@
+package main
+
+import "testing"
+
+// tibetanTerm builds a term from its Wylie spelling
+func tibetanTerm(t *testing.T, wylie string) TermData {
+	t.Helper()
+	uni, err := ewtsToUnicode(wylie)
+	if err != nil {
+		t.Fatalf("ewtsToUnicode(%q): %v", wylie, err)
+	}
+	return TermData{SearchTerm: uni, SearchTermWylie: wylie}
+}
+
+func TestSortTermsTibetan(t *testing.T) {
+	want := []string{
+		"ka", "ka ba", "kag", "ki", "kya", "kra", "dkar", "bka'", "rka",
+		"skad", "bskyed", "kha", "mkha'", "'khor", "ga", "nga", "lha",
+	}
+	inputs := map[string][]string{
+		"reversed": make([]string, len(want)),
+		"rotated":  append(append([]string{}, want[7:]...), want[:7]...),
+	}
+	for i, w := range want {
+		inputs["reversed"][len(want)-1-i] = w
+	}
+
+	for name, input := range inputs {
+		terms := make([]TermData, len(input))
+		for i, w := range input {
+			terms[i] = tibetanTerm(t, w)
+		}
+		sortTerms(terms, orderTibetan, nil)
+		for i, term := range terms {
+			if term.SearchTermWylie != want[i] {
+				t.Errorf("%s: position %d is %q, want %q", name, i, term.SearchTermWylie, want[i])
+			}
+		}
+	}
+}
+
+func TestTermRootLetter(t *testing.T) {
+	tests := []struct {
+		wylie, letter string
+	}{
+		{"ka", "ཀ"},
+		{"bka'", "ཀ"},
+		{"bskyed", "ཀ"},
+		{"'khor", "ཁ"},
+		{"mkha'", "ཁ"},
+		{"sgra", "ག"},
+		{"lha", "ཧ"},
+		{"g.yag", "ཡ"},
+	}
+	for _, tt := range tests {
+		if got := termRootLetter(tibetanTerm(t, tt.wylie)); got != tt.letter {
+			t.Errorf("termRootLetter(%q) = %q, want %q", tt.wylie, got, tt.letter)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+const formatCSV = "csv"
+
+// csvColumn says what one spreadsheet column holds
+type csvColumn struct {
+	field  string // wylie, unicode, source, definition, related or skip
+	source string // dictionary source for definition columns, if fixed
+	script string // for definition columns: "" (English), "wylie" or "unicode"
+}
+
+// csvSource reads glossaries kept in spreadsheets, exported as CSV or TSV.
+// Columns are mapped to TermData fields with a spec such as
+// "wylie,unicode,Hopkins 2015:definition,related" (see parseCSVColumns).
+type csvSource struct {
+	columns    []csvColumn // empty means: take the spec from the header row
+	header     bool        // the first row is a header and holds no term
+	relatedSep string      // separator between related terms in one cell
+}
+
+// defaultCSVSource is the registered CSV reader, configured from the command line
+var defaultCSVSource = &csvSource{header: true, relatedSep: ";"}
+
+func (s *csvSource) Name() string         { return formatCSV }
+func (s *csvSource) Extensions() []string { return []string{".csv", ".tsv"} }
+
+// Detect accepts any file with a CSV or TSV extension
+func (s *csvSource) Detect(doc *TermDocument) bool {
+	return true
+}
+
+// Configure sets the column mapping, the related-terms separator and whether
+// the first row is a header
+func (s *csvSource) Configure(spec, relatedSep string, header bool) error {
+	s.header = header
+	s.relatedSep = relatedSep
+	s.columns = nil
+	if spec == "" {
+		if !header {
+			return errors.New("-columns is required when CSV files have no header row")
+		}
+		return nil
+	}
+	columns, err := parseCSVColumns(strings.Split(spec, ","))
+	if err != nil {
+		return err
+	}
+	s.columns = columns
+	return nil
+}
+
+// parseCSVColumns parses a column spec, one entry per column:
+//
+//	wylie, unicode       the headword in Wylie or Tibetan script
+//	related              related terms separated by the related separator
+//	source               the dictionary source of this row's definition columns
+//	definition           a definition; its source comes from a "source"
+//	                     column, else the column header when -columns gave
+//	                     the spec, else the file name
+//	NAME:definition      a definition from dictionary source NAME
+//	NAME:wylie           source NAME's Wylie form (definitionsWylie)
+//	NAME:unicode         source NAME's Tibetan form (definitionsUnicode)
+//	skip or empty        an ignored column
+func parseCSVColumns(spec []string) ([]csvColumn, error) {
+	columns := make([]csvColumn, len(spec))
+	for i, entry := range spec {
+		entry = strings.TrimSpace(entry)
+
+		if name, kind, ok := cutLast(entry, ":"); ok {
+			col := csvColumn{field: "definition", source: strings.TrimSpace(name)}
+			switch strings.ToLower(strings.TrimSpace(kind)) {
+			case "definition":
+			case "wylie":
+				col.script = "wylie"
+			case "unicode":
+				col.script = "unicode"
+			default:
+				return nil, fmt.Errorf("column %d: unknown kind %q in %q (want definition, wylie or unicode)", i+1, kind, entry)
+			}
+			if col.source == "" {
+				return nil, fmt.Errorf("column %d: missing dictionary source name in %q", i+1, entry)
+			}
+			columns[i] = col
+			continue
+		}
+
+		switch field := strings.ToLower(entry); field {
+		case "wylie", "unicode", "related", "source", "definition":
+			columns[i] = csvColumn{field: field}
+		case "", "-", "skip":
+			columns[i] = csvColumn{field: "skip"}
+		default:
+			return nil, fmt.Errorf("column %d: unknown column %q", i+1, entry)
+		}
+	}
+	return columns, nil
+}
+
+// cutLast splits s around the last occurrence of sep
+func cutLast(s, sep string) (string, string, bool) {
+	i := strings.LastIndex(s, sep)
+	if i < 0 {
+		return s, "", false
+	}
+	return s[:i], s[i+len(sep):], true
+}
+
+// Read decodes every row into a term. Rows sharing a headword (one row per
+// source or sense) are combined before the terms are yielded.
+func (s *csvSource) Read(doc *TermDocument, yield func(TermData) error) error {
+	r := csv.NewReader(doc.Reader)
+	if hasAnySuffix(doc.Name, ".tsv") {
+		r.Comma = '\t'
+		r.LazyQuotes = true
+	}
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+
+	columns := s.columns
+	var header []string
+	fileSource := strings.TrimSuffix(filepath.Base(doc.Name), filepath.Ext(doc.Name))
+
+	rows := newTermSet(mergeJoin)
+	for row := 1; ; row++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				err = &lineError{Line: parseErr.Line, Column: parseErr.Column, Err: parseErr.Err}
+			}
+			yieldAll(rows.terms, yield)
+			return err
+		}
+
+		// Excel starts its CSV exports with a byte order mark
+		if row == 1 && len(record) > 0 {
+			record[0] = strings.TrimPrefix(record[0], "\ufeff")
+		}
+
+		if row == 1 && s.header {
+			// The header names the source of bare definition columns only
+			// when -columns gave the spec; otherwise it is the spec itself
+			if columns != nil {
+				header = record
+			}
+			if columns == nil {
+				if columns, err = parseCSVColumns(record); err != nil {
+					return &lineError{Line: 1, Column: 1, Err: fmt.Errorf("header row is not a column spec: %w", err)}
+				}
+			}
+			continue
+		}
+
+		term, ok := s.decodeRow(record, columns, header, fileSource)
+		if ok {
+			rows.add(term)
+		}
+	}
+
+	return yieldAll(rows.terms, yield)
+}
+
+// yieldAll passes each term to yield, stopping at the first error
+func yieldAll(terms []TermData, yield func(TermData) error) error {
+	for _, term := range terms {
+		if err := yield(term); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeRow maps one spreadsheet row onto a TermData. Rows without a headword are dropped.
+func (s *csvSource) decodeRow(record []string, columns []csvColumn, header []string, fileSource string) (TermData, bool) {
+	term := TermData{
+		Definitions:        make(map[string]string),
+		DefinitionsWylie:   make(map[string]string),
+		DefinitionsUnicode: make(map[string]string),
+	}
+
+	rowSource := ""
+	for i, col := range columns {
+		if col.field == "source" && i < len(record) {
+			rowSource = strings.TrimSpace(record[i])
+		}
+	}
+
+	for i, col := range columns {
+		if i >= len(record) {
+			break
+		}
+		value := strings.TrimSpace(record[i])
+		if value == "" {
+			continue
+		}
+
+		switch col.field {
+		case "wylie":
+			term.SearchTermWylie = value
+		case "unicode":
+			term.SearchTerm = value
+		case "related":
+			term.RelatedTerms = append(term.RelatedTerms, splitRelatedTerms(value, s.relatedSep)...)
+		case "definition":
+			source := col.source
+			if source == "" {
+				source = rowSource
+			}
+			if source == "" && i < len(header) {
+				source = strings.TrimSpace(header[i])
+			}
+			if source == "" {
+				source = fileSource
+			}
+			switch col.script {
+			case "wylie":
+				term.DefinitionsWylie[source] = value
+			case "unicode":
+				term.DefinitionsUnicode[source] = value
+			default:
+				term.Definitions[source] = value
+			}
+		}
+	}
+
+	if term.SearchTerm == "" && term.SearchTermWylie == "" {
+		return TermData{}, false
+	}
+	term.DefinitionsCount = len(term.Definitions)
+	term.RelatedTermsCount = len(term.RelatedTerms)
+	return term, true
+}
+
+// splitRelatedTerms splits a related-terms cell, filing each entry under
+// Unicode or Wylie according to its script
+func splitRelatedTerms(cell, sep string) []RelatedTerm {
+	var related []RelatedTerm
+	for _, item := range strings.Split(cell, sep) {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		related = append(related, relatedTermFor(item))
+	}
+	return related
+}
+
+// relatedTermFor files a term under Unicode or Wylie according to its script
+func relatedTermFor(word string) RelatedTerm {
+	if isTibetanScript(word) {
+		return RelatedTerm{Unicode: word}
+	}
+	return RelatedTerm{Wylie: word}
+}
+
+// isTibetanScript reports whether s contains any Tibetan-script letters
+func isTibetanScript(s string) bool {
+	for _, r := range s {
+		if unicode.Is(unicode.Tibetan, r) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Scripts of a DefinitionPart
+const (
+	scriptEnglish = "english"
+	scriptUnicode = "unicode"
+	scriptWylie   = "wylie"
+)
+
+// Definition is one entry of a dictionary source: a sense made of
+// script-tagged parts, with optional notes. Paged exports can give several
+// per source.
+type Definition struct {
+	Sense string           `json:"sense,omitempty"` // sense number or label, e.g. "1" or "a"
+	Parts []DefinitionPart `json:"parts"`
+	Notes []string         `json:"notes,omitempty"`
+}
+
+// DefinitionPart is a run of definition text in one script
+type DefinitionPart struct {
+	Script string `json:"script"` // english, unicode or wylie
+	Text   string `json:"text"`
+}
+
+// Keys of a paged definition object, by what they hold
+var (
+	definitionListKeys  = []string{"senses", "entries", "definitions", "meanings"}
+	definitionSenseKeys = []string{"sense", "senseNumber", "number", "label"}
+	definitionNoteKeys  = []string{"note", "notes", "comment", "comments"}
+	definitionTextKeys  = map[string][]string{
+		scriptUnicode: {"unicode", "tibetan"},
+		scriptWylie:   {"wylie"},
+		scriptEnglish: {"english", "text", "definition", "meaning", "gloss", "translation", "value"},
+	}
+)
+
+// parseDefinitionValue turns one decoded JSON definition value into
+// definitions. Strings become a single part, arrays one definition per
+// element, and objects are read by parseDefinitionObject.
+func parseDefinitionValue(v interface{}) []Definition {
+	switch val := v.(type) {
+	case nil:
+		return nil
+	case string:
+		if strings.TrimSpace(val) == "" {
+			return nil
+		}
+		return []Definition{{Parts: []DefinitionPart{textPart(val)}}}
+	case []interface{}:
+		var defs []Definition
+		for _, item := range val {
+			defs = append(defs, parseDefinitionValue(item)...)
+		}
+		return defs
+	case map[string]interface{}:
+		return parseDefinitionObject(val)
+	default:
+		return []Definition{{Parts: []DefinitionPart{{Script: scriptEnglish, Text: fmt.Sprint(val)}}}}
+	}
+}
+
+// parseDefinitionObject reads a definition object such as
+// {"unicode": ..., "wylie": ..., "english": ..., "sense": 1, "notes": [...]},
+// or {"script": "wylie", "text": ...}. A list under "senses" (or "entries",
+// "definitions", "meanings") gives one definition per element. Any other key
+// is kept as a note, so no text is lost.
+func parseDefinitionObject(obj map[string]interface{}) []Definition {
+	var def Definition
+	used := make(map[string]bool)
+
+	if script, ok := obj["script"].(string); ok {
+		if text := valueText(obj["text"]); text != "" {
+			def.Parts = append(def.Parts, DefinitionPart{Script: normaliseScript(script), Text: text})
+		}
+		used["script"], used["text"] = true, true
+	}
+
+	for _, script := range []string{scriptUnicode, scriptWylie, scriptEnglish} {
+		for _, key := range definitionTextKeys[script] {
+			if used[key] {
+				continue
+			}
+			if v, ok := obj[key]; ok {
+				used[key] = true
+				if text := valueText(v); text != "" {
+					def.Parts = append(def.Parts, DefinitionPart{Script: script, Text: text})
+				}
+			}
+		}
+	}
+
+	for _, key := range definitionSenseKeys {
+		if v, ok := obj[key]; ok {
+			used[key] = true
+			if def.Sense == "" {
+				def.Sense = valueText(v)
+			}
+		}
+	}
+
+	for _, key := range definitionNoteKeys {
+		if v, ok := obj[key]; ok {
+			used[key] = true
+			def.Notes = append(def.Notes, valueTexts(v)...)
+		}
+	}
+
+	var nested []Definition
+	for _, key := range definitionListKeys {
+		if v, ok := obj[key]; ok {
+			used[key] = true
+			nested = append(nested, parseDefinitionValue(v)...)
+		}
+	}
+
+	var extra []string
+	for key := range obj {
+		if !used[key] {
+			extra = append(extra, key)
+		}
+	}
+	sort.Strings(extra)
+	for _, key := range extra {
+		if text := valueText(obj[key]); text != "" {
+			def.Notes = append(def.Notes, key+": "+text)
+		}
+	}
+
+	if len(def.Parts) == 0 && len(def.Notes) == 0 && def.Sense == "" {
+		return nested
+	}
+	return append([]Definition{def}, nested...)
+}
+
+// normaliseScript maps the script names exports use to english, unicode or wylie
+func normaliseScript(script string) string {
+	switch strings.ToLower(script) {
+	case "unicode", "tibetan", "bo":
+		return scriptUnicode
+	case "wylie", "ewts", "bo-latn":
+		return scriptWylie
+	}
+	return scriptEnglish
+}
+
+// textPart makes a part from plain text, tagged by its script
+func textPart(text string) DefinitionPart {
+	if isTibetanScript(text) {
+		return DefinitionPart{Script: scriptUnicode, Text: text}
+	}
+	return DefinitionPart{Script: scriptEnglish, Text: text}
+}
+
+// valueText renders any decoded JSON value as plain text
+func valueText(v interface{}) string {
+	return strings.Join(valueTexts(v), "; ")
+}
+
+// valueTexts renders a decoded JSON value as plain text, one string per
+// array element. Objects are read as definitions, never printed as Go maps.
+func valueTexts(v interface{}) []string {
+	switch val := v.(type) {
+	case nil:
+		return nil
+	case string:
+		if s := strings.TrimSpace(val); s != "" {
+			return []string{s}
+		}
+		return nil
+	case []interface{}:
+		var texts []string
+		for _, item := range val {
+			texts = append(texts, valueTexts(item)...)
+		}
+		return texts
+	case map[string]interface{}:
+		if text := flattenDefinitions(parseDefinitionObject(val)); text != "" {
+			return []string{text}
+		}
+		return nil
+	default:
+		return []string{fmt.Sprint(val)}
+	}
+}
+
+// flattenDefinitions renders definitions as one plain string, the form kept in
+// TermData.Definitions. A unicode/wylie pair reads "uni (wylie)".
+func flattenDefinitions(defs []Definition) string {
+	texts := make([]string, 0, len(defs))
+	for _, d := range defs {
+		if text := d.text(); text != "" {
+			texts = append(texts, text)
+		}
+	}
+	return strings.Join(texts, "; ")
+}
+
+// text renders one definition as plain text
+func (d Definition) text() string {
+	var words []string
+	if d.Sense != "" {
+		words = append(words, senseLabel(d.Sense))
+	}
+	for i, p := range d.Parts {
+		if p.Script == scriptWylie && i > 0 && d.Parts[i-1].Script == scriptUnicode {
+			words = append(words, "("+p.Text+")")
+		} else {
+			words = append(words, p.Text)
+		}
+	}
+	for _, note := range d.Notes {
+		words = append(words, "["+note+"]")
+	}
+	return strings.Join(words, " ")
+}
+
+// englishDefinition returns the English text of a term's definition from
+// source, one line per definition. Structured definitions give only their
+// English parts, without sense labels, Tibetan or notes.
+func englishDefinition(t TermData, source string) string {
+	defs, ok := t.StructuredDefinitions[source]
+	if !ok {
+		return t.Definitions[source]
+	}
+	var lines []string
+	for _, d := range defs {
+		var words []string
+		for _, p := range d.Parts {
+			if p.Script == scriptEnglish {
+				words = append(words, p.Text)
+			}
+		}
+		if len(words) > 0 {
+			lines = append(lines, strings.Join(words, " "))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// senseLabel writes a sense number as "1." and leaves labels such as "a)" alone
+func senseLabel(sense string) string {
+	if strings.HasSuffix(sense, ".") || strings.HasSuffix(sense, ")") {
+		return sense
+	}
+	return sense + "."
+}
+
+// formatStructuredDefinitions renders a source's definitions as XHTML, one
+// paragraph per definition, with each part in its script's span. Parts in
+// scripts not chosen are left out, and so are definitions left with none.
+func formatStructuredDefinitions(defs []Definition, scripts definitionScripts) string {
+	var b strings.Builder
+	for _, d := range defs {
+		var parts []DefinitionPart
+		for _, p := range d.Parts {
+			if scripts[p.Script] {
+				parts = append(parts, p)
+			}
+		}
+		if len(parts) == 0 {
+			continue
+		}
+
+		var words []string
+		if d.Sense != "" {
+			words = append(words, fmt.Sprintf(`<span class="sense">%s</span>`, escapeXML(senseLabel(d.Sense))))
+		}
+		for i, p := range parts {
+			switch p.Script {
+			case scriptUnicode:
+				words = append(words, fmt.Sprintf(`<span class="unicode">%s</span>`, escapeXML(p.Text)))
+			case scriptWylie:
+				span := fmt.Sprintf(`<span class="wylie">%s</span>`, escapeXML(p.Text))
+				if i > 0 && parts[i-1].Script == scriptUnicode {
+					span = "(" + span + ")"
+				}
+				words = append(words, span)
+			default:
+				words = append(words, escapeXML(formatDefinitionText(p.Text)))
+			}
+		}
+		for _, note := range d.Notes {
+			words = append(words, fmt.Sprintf(`<span class="note">%s</span>`, escapeXML(note)))
+		}
+		fmt.Fprintf(&b, "      <p>%s</p>\n", strings.Join(words, " "))
+	}
+	return b.String()
+}
+
+// definitionsOrText returns defs, or text as a single definition if defs is empty
+func definitionsOrText(defs []Definition, text string) []Definition {
+	if len(defs) > 0 || text == "" {
+		return defs
+	}
+	return []Definition{{Parts: []DefinitionPart{textPart(text)}}}
+}
+
+// mergeStructuredDefinitions keeps existing.StructuredDefinitions in step
+// with its merged plain text. before holds existing.Definitions as it was
+// before t was merged in. For each source the structured form whose text won
+// is kept; joined text gets both lists.
+func mergeStructuredDefinitions(existing *TermData, before map[string]string, t TermData) {
+	if len(existing.StructuredDefinitions) == 0 && len(t.StructuredDefinitions) == 0 {
+		return
+	}
+
+	merged := make(map[string][]Definition)
+	for source, text := range existing.Definitions {
+		old, hasOld := existing.StructuredDefinitions[source]
+		incoming, hasIncoming := t.StructuredDefinitions[source]
+		switch {
+		case !hasOld && !hasIncoming:
+		case hasIncoming && flattenDefinitions(incoming) == text:
+			merged[source] = incoming
+		case hasOld && flattenDefinitions(old) == text:
+			merged[source] = old
+		default:
+			joined := append([]Definition(nil), definitionsOrText(old, before[source])...)
+			merged[source] = append(joined, definitionsOrText(incoming, t.Definitions[source])...)
+		}
+	}
+	existing.StructuredDefinitions = merged
+}
+
+// fillDefinitionText sets the plain text of sources that only have a
+// structured form, as per-term files may give
+func fillDefinitionText(t *TermData) {
+	for source, defs := range t.StructuredDefinitions {
+		if t.Definitions[source] != "" {
+			continue
+		}
+		if t.Definitions == nil {
+			t.Definitions = make(map[string]string)
+		}
+		t.Definitions[source] = flattenDefinitions(defs)
+		t.DefinitionsCount = len(t.Definitions)
+	}
+}
+
+// definitionScripts is the set of variants shown for each source's
+// definition: english, unicode and wylie
+type definitionScripts map[string]bool
+
+// allDefinitionScripts is the default -definition-scripts value
+const allDefinitionScripts = scriptEnglish + "," + scriptUnicode + "," + scriptWylie
+
+// parseDefinitionScripts parses a comma-separated -definition-scripts list
+func parseDefinitionScripts(spec string) (definitionScripts, error) {
+	scripts := make(definitionScripts)
+	for _, script := range strings.Split(spec, ",") {
+		switch script = strings.ToLower(strings.TrimSpace(script)); script {
+		case "":
+		case scriptEnglish, scriptUnicode, scriptWylie:
+			scripts[script] = true
+		default:
+			return nil, fmt.Errorf("unknown definition script %q (want %s, %s or %s)", script, scriptEnglish, scriptUnicode, scriptWylie)
+		}
+	}
+	if len(scripts) == 0 {
+		return nil, fmt.Errorf("no definition scripts given (want %s, %s or %s)", scriptEnglish, scriptUnicode, scriptWylie)
+	}
+	return scripts, nil
+}
+
+// formatDefinitionVariant renders a source's Unicode or Wylie definition as
+// a paragraph in its script's span
+func formatDefinitionVariant(script, text string) string {
+	return fmt.Sprintf("      <p class=\"variant\"><span class=\"%s\">%s</span></p>\n", script, escapeXML(strings.TrimSpace(text)))
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"path"
+	"sort"
+	"strings"
+)
+
+// sourceOrder is the order dictionary sources are shown in: sources matching
+// an entry of the list come first, in list order, and the rest follow
+// alphabetically. Entries may be glob patterns ("Hopkins*") and match
+// without regard to case.
+type sourceOrder []string
+
+// parseSourceOrder parses a comma-separated -source-order list
+func parseSourceOrder(spec string) sourceOrder {
+	var order sourceOrder
+	for _, entry := range strings.Split(spec, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			order = append(order, entry)
+		}
+	}
+	return order
+}
+
+// matchSourcePattern reports whether a source name matches a pattern,
+// ignoring case. A malformed pattern only matches itself.
+func matchSourcePattern(pattern, source string) bool {
+	pattern, source = strings.ToLower(pattern), strings.ToLower(source)
+	if ok, err := path.Match(pattern, source); err == nil {
+		return ok
+	}
+	return pattern == source
+}
+
+// rank returns the position of the first entry matching source, or
+// len(o) if none does
+func (o sourceOrder) rank(source string) int {
+	for i, pattern := range o {
+		if matchSourcePattern(pattern, source) {
+			return i
+		}
+	}
+	return len(o)
+}
+
+// sort orders source names in place
+func (o sourceOrder) sort(sources []string) {
+	sort.SliceStable(sources, func(i, j int) bool {
+		ri, rj := o.rank(sources[i]), o.rank(sources[j])
+		if ri != rj {
+			return ri < rj
+		}
+		return sources[i] < sources[j]
+	})
+}
+
+// sources returns the sources that have text in any of the definitions
+// maps, in order
+func (o sourceOrder) sources(maps ...map[string]string) []string {
+	var sources []string
+	seen := make(map[string]bool)
+	for _, defs := range maps {
+		for source, def := range defs {
+			if def != "" && !seen[source] {
+				seen[source] = true
+				sources = append(sources, source)
+			}
+		}
+	}
+	o.sort(sources)
+	return sources
+}
+
+// sourceFilter selects the dictionary sources an edition includes. Patterns
+// are globs matched without regard to case; an empty include list means all.
+type sourceFilter struct {
+	include []string
+	exclude []string
+}
+
+// active reports whether the filter drops anything
+func (f sourceFilter) active() bool {
+	return len(f.include) > 0 || len(f.exclude) > 0
+}
+
+// allows reports whether definitions from source are kept
+func (f sourceFilter) allows(source string) bool {
+	for _, pattern := range f.exclude {
+		if matchSourcePattern(pattern, source) {
+			return false
+		}
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, pattern := range f.include {
+		if matchSourcePattern(pattern, source) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterSources removes definitions from sources the filter does not allow,
+// in every script, and updates the definition counts. Terms the filter left
+// with no definitions are dropped unless keepEmpty is set; terms that had
+// none to begin with, such as related-only rows, are kept. It returns the kept terms,
+// the number of definitions removed and the number of terms dropped.
+func filterSources(terms []TermData, f sourceFilter, keepEmpty bool) ([]TermData, int, int) {
+	if !f.active() {
+		return terms, 0, 0
+	}
+
+	removed, dropped := 0, 0
+	kept := terms[:0]
+	for _, t := range terms {
+		n := filterSourceMap(t.Definitions, f)
+		removed += n
+		n += filterSourceMap(t.DefinitionsWylie, f)
+		n += filterSourceMap(t.DefinitionsUnicode, f)
+		for source := range t.StructuredDefinitions {
+			if !f.allows(source) {
+				delete(t.StructuredDefinitions, source)
+			}
+		}
+		t.DefinitionsCount = len(t.Definitions)
+
+		if !keepEmpty && n > 0 && len(t.Definitions) == 0 && len(t.DefinitionsWylie) == 0 && len(t.DefinitionsUnicode) == 0 {
+			dropped++
+			continue
+		}
+		kept = append(kept, t)
+	}
+	return kept, removed, dropped
+}
+
+// filterSourceMap deletes the entries of disallowed sources and empty
+// entries from a definitions map, returning how many non-empty ones it removed
+func filterSourceMap(defs map[string]string, f sourceFilter) int {
+	removed := 0
+	for source, text := range defs {
+		if text == "" {
+			delete(defs, source)
+		} else if !f.allows(source) {
+			delete(defs, source)
+			removed++
+		}
+	}
+	return removed
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// stringListFlag collects repeated occurrences of a command-line flag
+type stringListFlag []string
+
+func (s *stringListFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringListFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// isInputFile reports whether a file found while walking an input root
+// should be read: a term export, gzipped or not, or an archive of them
+func isInputFile(name string) bool {
+	return isTermFile(name) || isGzipFile(name) || isArchiveFile(name)
+}
+
+// hasAnySuffix reports whether name ends in one of the suffixes, ignoring case
+func hasAnySuffix(name string, suffixes ...string) bool {
+	lower := strings.ToLower(name)
+	for _, suffix := range suffixes {
+		if strings.HasSuffix(lower, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// findInputFiles walks each input root recursively and returns the term files
+// that pass the include/exclude patterns. Roots may also name a single file,
+// or "-" for standard input.
+// Files reachable from several roots are only returned once.
+func findInputFiles(roots, include, exclude []string) ([]string, error) {
+	var files []string
+	seen := make(map[string]bool)
+
+	add := func(p string) {
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			abs = filepath.Clean(p)
+		}
+		if seen[abs] {
+			return
+		}
+		seen[abs] = true
+		files = append(files, p)
+	}
+
+	for _, root := range roots {
+		if root == stdinInput {
+			files = append(files, root)
+			continue
+		}
+
+		info, err := os.Stat(root)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read input %s: %w", root, err)
+		}
+
+		// An explicitly named file is taken as-is
+		if !info.IsDir() {
+			add(root)
+			continue
+		}
+
+		err = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return fmt.Errorf("failed to read directory %s: %w", p, err)
+			}
+			if d.IsDir() || !isInputFile(d.Name()) {
+				return nil
+			}
+
+			rel, err := filepath.Rel(root, p)
+			if err != nil {
+				rel = d.Name()
+			}
+			rel = filepath.ToSlash(rel)
+
+			if len(include) > 0 && !matchAnyGlob(include, rel) {
+				return nil
+			}
+			if matchAnyGlob(exclude, rel) {
+				return nil
+			}
+
+			add(p)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return files, nil
+}
+
+// matchAnyGlob reports whether rel matches at least one of the patterns
+func matchAnyGlob(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		if matchGlob(pattern, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGlob matches a slash-separated relative path against a glob pattern.
+// A "**" segment matches any number of directories. As in .gitignore, a
+// pattern without a slash is matched against every directory and file name
+// in the path, so "*draft*" excludes drafts/ka.json as well as ka-draft.json.
+func matchGlob(pattern, rel string) bool {
+	pattern = filepath.ToSlash(pattern)
+	if !strings.Contains(pattern, "/") {
+		for _, segment := range strings.Split(rel, "/") {
+			if ok, _ := path.Match(pattern, segment); ok {
+				return true
+			}
+		}
+		return false
+	}
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(rel, "/"))
+}
+
+func matchSegments(pattern, parts []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// Collapse consecutive "**" and try every possible split point
+			for len(pattern) > 0 && pattern[0] == "**" {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := 0; i <= len(parts); i++ {
+				if matchSegments(pattern, parts[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(parts) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], parts[0]); !ok {
+			return false
+		}
+		pattern = pattern[1:]
+		parts = parts[1:]
+	}
+	return len(parts) == 0
+}
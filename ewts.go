@@ -0,0 +1,766 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// EWTS (Extended Wylie Transliteration Scheme) to Tibetan Unicode.
+//
+// Input is split into tokens (consonants, vowels, finals, punctuation), then
+// into syllables at each tsheg. Within a syllable the consonants before the
+// vowel are resolved into prefix, superscript, root and subscripts by the
+// native spelling rules; "." forces a prefix ("g.yag") and "+" forces a
+// stack ("k+Sh"). Consonants after the vowel are suffixes, unless another
+// vowel follows, in which case they start a new stack ("ba'i", "badzra").
+
+// ewtsConsonants maps EWTS consonants to Tibetan letters
+var ewtsConsonants = map[string]string{
+	"k": "ཀ", "kh": "ཁ", "g": "ག", "gh": "གྷ", "ng": "ང",
+	"c": "ཅ", "ch": "ཆ", "j": "ཇ", "ny": "ཉ",
+	"T": "ཊ", "Th": "ཋ", "D": "ཌ", "Dh": "ཌྷ", "N": "ཎ",
+	"t": "ཏ", "th": "ཐ", "d": "ད", "dh": "དྷ", "n": "ན",
+	"p": "པ", "ph": "ཕ", "b": "བ", "bh": "བྷ", "m": "མ",
+	"ts": "ཙ", "tsh": "ཚ", "dz": "ཛ", "dzh": "ཛྷ", "w": "ཝ",
+	"zh": "ཞ", "z": "ཟ", "'": "འ", "y": "ཡ", "r": "ར", "l": "ལ",
+	"sh": "ཤ", "Sh": "ཥ", "s": "ས", "h": "ཧ",
+	"kSh": "ཀྵ", "f": "ཕ༹", "v": "བ༹",
+	"R": "ཪ", "W": "ཝ", "Y": "ཡ",
+}
+
+// ewtsFixedSubjoined holds subjoined forms that are not the letter plus 0x50
+var ewtsFixedSubjoined = map[string]string{
+	"R": "ྼ", "W": "ྺ", "Y": "ྻ",
+}
+
+// ewtsVowels maps EWTS vowels to vowel signs; "a" is the inherent vowel
+var ewtsVowels = map[string]string{
+	"a": "", "A": "ཱ", "i": "ི", "I": "ཱི", "u": "ུ", "U": "ཱུ",
+	"e": "ེ", "ai": "ཻ", "o": "ོ", "au": "ཽ",
+	"-i": "ྀ", "-I": "ཱྀ",
+	"r-i": "ྲྀ", "r-I": "ྲཱྀ", "l-i": "ླྀ", "l-I": "ླཱྀ",
+}
+
+// ewtsStandaloneVowels are vowels written with their own full letter when no
+// consonant carries them; other vowels are written on a-chen (ཨ)
+var ewtsStandaloneVowels = map[string]string{
+	"r-i": "རྀ", "r-I": "རཱྀ", "l-i": "ལྀ", "l-I": "ལཱྀ",
+}
+
+// ewtsFinals are marks written after the vowel
+var ewtsFinals = map[string]string{
+	"M": "ཾ", "~M`": "ྂ", "~M": "ྃ", "H": "ཿ", "?": "྄",
+}
+
+// ewtsSymbols maps punctuation and digits; " " is handled separately as tsheg
+var ewtsSymbols = map[string]string{
+	"*": "༌", "/": "།", "//": "༎", ";": "༏", "|": "༑",
+	"!": "༈", ":": "༔", "_": " ", "=": "༴",
+	"<": "༺", ">": "༻", "(": "༼", ")": "༽",
+	"@": "༄", "#": "༅", "$": "༆", "%": "༇", "&": "྅",
+	"0": "༠", "1": "༡", "2": "༢", "3": "༣", "4": "༤",
+	"5": "༥", "6": "༦", "7": "༧", "8": "༨", "9": "༩",
+	"\n": "\n", "\t": "\t", "\r": "\r",
+}
+
+// Native stacking rules: which roots take a given superscript, subscript or prefix
+var (
+	ewtsSuperscripts = map[string]string{
+		"r": " k g ng j ny t d n b m ts dz ",
+		"l": " k g ng c j t d p b h ",
+		"s": " k g ng ny t d n p b m ts ",
+	}
+	ewtsSubscripts = map[string]string{
+		"y": " k kh g p ph b m h ",
+		"r": " k kh g t th d n p ph b m sh s h dz ",
+		"l": " k g b z r s ",
+		"w": " k kh g c ny t d ts tsh zh z r l sh s h ",
+	}
+	ewtsPrefixes = map[string]bool{"g": true, "d": true, "b": true, "m": true, "'": true}
+)
+
+// ewtsTokens lists every multi-character token, longest first, for tokenizing
+var ewtsTokens = func() []string {
+	var tokens []string
+	for _, table := range []map[string]string{ewtsConsonants, ewtsVowels, ewtsFinals, ewtsSymbols} {
+		for t := range table {
+			tokens = append(tokens, t)
+		}
+	}
+	tokens = append(tokens, "+", ".", " ")
+	sort.Slice(tokens, func(i, j int) bool {
+		if len(tokens[i]) != len(tokens[j]) {
+			return len(tokens[i]) > len(tokens[j])
+		}
+		return tokens[i] < tokens[j]
+	})
+	return tokens
+}()
+
+// ewtsTokenize splits EWTS text into tokens. Text in [brackets] and \uXXXX
+// escapes are kept as single tokens.
+func ewtsTokenize(s string) []string {
+	var tokens []string
+	for i := 0; i < len(s); {
+		if s[i] == '[' {
+			if end := strings.IndexByte(s[i:], ']'); end > 0 {
+				tokens = append(tokens, s[i:i+end+1])
+				i += end + 1
+				continue
+			}
+		}
+		if s[i] == '\\' && i+6 <= len(s) && (s[i+1] == 'u' || s[i+1] == 'U') {
+			tokens = append(tokens, s[i:i+6])
+			i += 6
+			continue
+		}
+
+		matched := ""
+		for _, t := range ewtsTokens {
+			if strings.HasPrefix(s[i:], t) {
+				matched = t
+				break
+			}
+		}
+		if matched == "" {
+			_, size := utf8.DecodeRuneInString(s[i:])
+			matched = s[i : i+size]
+		}
+		tokens = append(tokens, matched)
+		i += len(matched)
+	}
+	return tokens
+}
+
+func isEWTSConsonant(t string) bool {
+	_, ok := ewtsConsonants[t]
+	return ok
+}
+
+func isEWTSVowel(t string) bool {
+	_, ok := ewtsVowels[t]
+	return ok
+}
+
+func isEWTSFinal(t string) bool {
+	_, ok := ewtsFinals[t]
+	return ok
+}
+
+// isEWTSSyllableToken reports whether a token belongs inside a syllable
+func isEWTSSyllableToken(t string) bool {
+	return isEWTSConsonant(t) || isEWTSVowel(t) || isEWTSFinal(t) || t == "+" || t == "."
+}
+
+// ewtsToUnicode converts EWTS text to Tibetan Unicode. It returns an error
+// listing any characters that are not valid EWTS; the rest is still converted.
+func ewtsToUnicode(s string) (string, error) {
+	tokens := ewtsTokenize(s)
+	var out strings.Builder
+	var unknown []string
+
+	for i := 0; i < len(tokens); {
+		t := tokens[i]
+		switch {
+		case isEWTSSyllableToken(t):
+			j := i
+			for j < len(tokens) && isEWTSSyllableToken(tokens[j]) {
+				j++
+			}
+			out.WriteString(ewtsSyllable(tokens[i:j]))
+			i = j
+			continue
+		case t == " ":
+			// A space before or after a shad is a real space, otherwise a tsheg
+			prevShad := strings.HasSuffix(out.String(), "།") || strings.HasSuffix(out.String(), "༎")
+			nextShad := i+1 < len(tokens) && (tokens[i+1] == "/" || tokens[i+1] == "//")
+			if prevShad || nextShad {
+				out.WriteString(" ")
+			} else {
+				out.WriteString("་")
+			}
+		case strings.HasPrefix(t, "[") && strings.HasSuffix(t, "]"):
+			out.WriteString(t[1 : len(t)-1])
+		case len(t) == 6 && t[0] == '\\':
+			if r, err := strconv.ParseUint(t[2:], 16, 32); err == nil {
+				out.WriteRune(rune(r))
+			} else {
+				unknown = append(unknown, t)
+			}
+		default:
+			if sym, ok := ewtsSymbols[t]; ok {
+				out.WriteString(sym)
+			} else {
+				unknown = append(unknown, t)
+				out.WriteString(t)
+			}
+		}
+		i++
+	}
+
+	if len(unknown) > 0 {
+		return out.String(), fmt.Errorf("not valid EWTS: %q", strings.Join(unknown, ""))
+	}
+	return out.String(), nil
+}
+
+// ewtsSyllable converts the tokens of one syllable (tsheg-bar)
+func ewtsSyllable(tokens []string) string {
+	var out strings.Builder
+	sawVowel := false
+
+	for i := 0; i < len(tokens); {
+		// Collect the consonant run up to the next vowel, as groups split by "."
+		var groups [][]string
+		var current []string
+		explicit := false
+		for i < len(tokens) && !isEWTSVowel(tokens[i]) && !isEWTSFinal(tokens[i]) {
+			switch t := tokens[i]; t {
+			case ".":
+				if len(current) > 0 {
+					groups = append(groups, current)
+				}
+				current = nil
+			case "+":
+				explicit = true
+			default:
+				current = append(current, t)
+			}
+			i++
+		}
+		if len(current) > 0 {
+			groups = append(groups, current)
+		}
+
+		vowel, hasVowel := "", false
+		if i < len(tokens) && isEWTSVowel(tokens[i]) {
+			vowel, hasVowel = tokens[i], true
+			i++
+		}
+		var finals []string
+		for i < len(tokens) && isEWTSFinal(tokens[i]) {
+			finals = append(finals, tokens[i])
+			i++
+		}
+
+		var stacks [][]string
+		switch {
+		case sawVowel && !hasVowel && !explicit:
+			// Suffixes after the vowel are written as plain letters
+			for _, g := range groups {
+				for _, c := range g {
+					stacks = append(stacks, []string{c})
+				}
+			}
+		case sawVowel || explicit:
+			// A run between two vowels, or joined with "+", is one stack per group
+			stacks = groups
+		default:
+			for _, g := range groups {
+				stacks = append(stacks, resolveEWTSStacks(g)...)
+			}
+		}
+
+		for n, stack := range stacks {
+			out.WriteString(ewtsStack(stack))
+			if n == len(stacks)-1 && hasVowel {
+				out.WriteString(ewtsVowels[vowel])
+			}
+		}
+		if hasVowel && len(stacks) == 0 {
+			// A vowel with no consonant is written on a-chen
+			if standalone, ok := ewtsStandaloneVowels[vowel]; ok {
+				out.WriteString(standalone)
+			} else {
+				out.WriteString("ཨ" + ewtsVowels[vowel])
+			}
+		}
+		for _, f := range finals {
+			out.WriteString(ewtsFinals[f])
+		}
+
+		if hasVowel {
+			sawVowel = true
+		}
+	}
+
+	return out.String()
+}
+
+// resolveEWTSStacks splits the consonants before a vowel into an optional
+// prefix and the stack that carries the vowel. A run that fits no native
+// pattern is stacked whole, as Sanskrit clusters are.
+func resolveEWTSStacks(cs []string) [][]string {
+	if len(cs) <= 1 || isNativeStack(cs) {
+		return [][]string{cs}
+	}
+	if ewtsPrefixes[cs[0]] && (len(cs) == 2 || isNativeStack(cs[1:])) {
+		return [][]string{cs[:1], cs[1:]}
+	}
+	return [][]string{cs}
+}
+
+// isNativeStack reports whether consonants form one stack under the native
+// rules: superscript+root, root+subscript, superscript+root+subscript, or
+// root+subscript+wa-zur
+func isNativeStack(cs []string) bool {
+	switch len(cs) {
+	case 1:
+		return true
+	case 2:
+		return takesSuperscript(cs[0], cs[1]) || takesSubscript(cs[0], cs[1])
+	case 3:
+		if takesSuperscript(cs[0], cs[1]) && takesSubscript(cs[1], cs[2]) {
+			return true
+		}
+		return takesSubscript(cs[0], cs[1]) && cs[2] == "w"
+	}
+	return false
+}
+
+func takesSuperscript(super, root string) bool {
+	return strings.Contains(ewtsSuperscripts[super], " "+root+" ")
+}
+
+func takesSubscript(root, sub string) bool {
+	return strings.Contains(ewtsSubscripts[sub], " "+root+" ")
+}
+
+// ewtsStack writes a stack: the first consonant in full form, the rest subjoined
+func ewtsStack(cs []string) string {
+	var b strings.Builder
+	for i, c := range cs {
+		if i == 0 {
+			b.WriteString(ewtsConsonants[c])
+		} else {
+			b.WriteString(subjoinedForm(c))
+		}
+	}
+	return b.String()
+}
+
+// subjoinedForm returns the subjoined form of a consonant. Letters in the
+// range U+0F40..U+0F6C have their subjoined form 0x50 higher.
+func subjoinedForm(c string) string {
+	if fixed, ok := ewtsFixedSubjoined[c]; ok {
+		return fixed
+	}
+	full := ewtsConsonants[c]
+	r, size := utf8.DecodeRuneInString(full)
+	if r >= 0x0f40 && r <= 0x0f6c {
+		return string(r+0x50) + full[size:]
+	}
+	return full
+}
+
+// looksLikeWylie reports whether s is Wylie: no Tibetan script, at least one
+// letter, and valid EWTS throughout
+func looksLikeWylie(s string) bool {
+	s = strings.TrimSpace(s)
+	if s == "" || isTibetanScript(s) {
+		return false
+	}
+	hasLetter := false
+	for _, t := range ewtsTokenize(s) {
+		if isEWTSConsonant(t) || isEWTSVowel(t) {
+			hasLetter = true
+		}
+	}
+	if !hasLetter {
+		return false
+	}
+	_, err := ewtsToUnicode(s)
+	return err == nil
+}
+
+// wylieToHeadword converts a Wylie headword to Unicode in the style of the
+// CLI exports, which end each headword with a tsheg
+func wylieToHeadword(wylie string) (string, bool) {
+	uni, err := ewtsToUnicode(strings.TrimSpace(wylie))
+	if err != nil || uni == "" {
+		return "", false
+	}
+	if last, _ := utf8.DecodeLastRuneInString(uni); last >= 0x0f40 && last <= 0x0fbc {
+		uni += "་"
+	}
+	return uni, true
+}
+
+// fillTibetanScript completes a term's Tibetan-script forms from Wylie. A
+// Wylie headword in SearchTerm (as in per-term exports) is moved to
+// SearchTermWylie, and missing Unicode headwords and related terms are
+// converted from Wylie. It returns how many forms were generated.
+func fillTibetanScript(t *TermData) int {
+	generated := 0
+
+	if t.SearchTermWylie == "" && looksLikeWylie(t.SearchTerm) {
+		t.SearchTermWylie, t.SearchTerm = t.SearchTerm, ""
+	}
+	if t.SearchTerm == "" && isTibetanScript(t.SearchTermWylie) {
+		t.SearchTerm, t.SearchTermWylie = t.SearchTermWylie, ""
+	}
+	if t.SearchTerm == "" && t.SearchTermWylie != "" {
+		if uni, ok := wylieToHeadword(t.SearchTermWylie); ok {
+			t.SearchTerm, t.SearchTermGenerated = uni, true
+			generated++
+		}
+	}
+
+	for i := range t.RelatedTerms {
+		rel := &t.RelatedTerms[i]
+		if rel.Wylie == "" && looksLikeWylie(rel.Unicode) {
+			rel.Wylie, rel.Unicode = rel.Unicode, ""
+		}
+		if rel.Unicode == "" && isTibetanScript(rel.Wylie) {
+			rel.Unicode, rel.Wylie = rel.Wylie, ""
+		}
+		if rel.Unicode == "" && rel.Wylie != "" {
+			if uni, ok := wylieToHeadword(rel.Wylie); ok {
+				rel.Unicode, rel.UnicodeGenerated = uni, true
+				generated++
+			}
+		}
+	}
+
+	return generated
+}
+
+// Tibetan Unicode to EWTS.
+//
+// Each syllable is split into stacks (a letter plus its subjoined letters,
+// vowel signs and finals). The root stack is the one carrying the vowel, or,
+// without a vowel sign, the one the native spelling rules pick; the inherent
+// "a" is written after it. Each candidate is converted back with
+// ewtsToUnicode, and a "." after the prefix is added if needed to round-trip.
+
+// ewtsPrefixRoots lists the root letters each prefix can stand before
+var ewtsPrefixRoots = map[string]string{
+	"g": " c ny t d n ts zh z y sh s ",
+	"d": " k g ng p b m ",
+	"b": " k g c t d ts zh z sh s ",
+	"m": " kh g ng ch j ny th d n tsh dz ",
+	"'": " kh g ch j th d ph b tsh dz ",
+}
+
+// ewtsSuffixes are the letters that can close a syllable after the root
+var ewtsSuffixes = map[string]bool{
+	"g": true, "ng": true, "d": true, "n": true, "b": true,
+	"m": true, "'": true, "r": true, "l": true, "s": true,
+}
+
+// tibetanLetters maps Tibetan letters, full or subjoined, back to EWTS
+var tibetanLetters = func() map[rune]string {
+	letters := map[rune]string{
+		'\u0f68': "a", '\u0fb8': "a", '\u0fb0': "'",
+		// precomposed aspirates and kSha, full and subjoined
+		'\u0f43': "gh", '\u0f4d': "Dh", '\u0f52': "dh", '\u0f57': "bh", '\u0f5c': "dzh", '\u0f69': "kSh",
+		'\u0f93': "gh", '\u0f9d': "Dh", '\u0fa2': "dh", '\u0fa7': "bh", '\u0fac': "dzh", '\u0fb9': "kSh",
+	}
+	for c, full := range ewtsConsonants {
+		if c == "W" || c == "Y" || utf8.RuneCountInString(full) != 1 {
+			continue
+		}
+		r, _ := utf8.DecodeRuneInString(full)
+		letters[r] = c
+		if sub, _ := utf8.DecodeRuneInString(subjoinedForm(c)); sub != r {
+			letters[sub] = c
+		}
+	}
+	for c, sub := range ewtsFixedSubjoined {
+		r, _ := utf8.DecodeRuneInString(sub)
+		letters[r] = c
+	}
+	return letters
+}()
+
+// tibetanMarks maps vowel signs and finals back to EWTS
+var tibetanMarks = map[rune]string{
+	'\u0f71': "A", '\u0f72': "i", '\u0f73': "I", '\u0f74': "u", '\u0f75': "U",
+	'\u0f7a': "e", '\u0f7b': "ai", '\u0f7c': "o", '\u0f7d': "au",
+	'\u0f80': "-i", '\u0f81': "-I",
+	'\u0f7e': "M", '\u0f82': "~M`", '\u0f83': "~M", '\u0f7f': "H", '\u0f84': "?",
+}
+
+// tibetanSymbols maps punctuation and digits back to EWTS
+var tibetanSymbols = func() map[rune]string {
+	symbols := map[rune]string{'\u0f0b': " ", ' ': "_"}
+	for w, uni := range ewtsSymbols {
+		if r, size := utf8.DecodeRuneInString(uni); size == len(uni) && r > 0x7f {
+			symbols[r] = w
+		}
+	}
+	return symbols
+}()
+
+// tibetanStack is one stack of a syllable
+type tibetanStack struct {
+	letters []string // EWTS consonants, top to bottom
+	vowel   string   // EWTS vowel, "" for the inherent a
+	finals  string   // anusvara, visarga and the like
+}
+
+// unicodeToEWTS converts Tibetan Unicode to EWTS. Text in other scripts is
+// kept in [brackets].
+func unicodeToEWTS(s string) string {
+	var out strings.Builder
+	var syllable []rune
+	var foreign []rune
+
+	flushSyllable := func() {
+		if len(syllable) > 0 {
+			out.WriteString(tibetanSyllableToEWTS(syllable))
+			syllable = nil
+		}
+	}
+	flushForeign := func() {
+		if len(foreign) > 0 {
+			out.WriteString("[" + string(foreign) + "]")
+			foreign = nil
+		}
+	}
+
+	for _, r := range s {
+		_, letter := tibetanLetters[r]
+		_, mark := tibetanMarks[r]
+		switch {
+		case letter || mark || r == '\u0f39':
+			flushForeign()
+			syllable = append(syllable, r)
+		case tibetanSymbols[r] != "":
+			flushSyllable()
+			flushForeign()
+			out.WriteString(tibetanSymbols[r])
+		default:
+			flushSyllable()
+			foreign = append(foreign, r)
+		}
+	}
+	flushSyllable()
+	flushForeign()
+
+	return out.String()
+}
+
+// tibetanSyllableToEWTS converts the letters and marks of one syllable
+func tibetanSyllableToEWTS(runes []rune) string {
+	stacks := tibetanStacks(runes)
+
+	// Prefer the plainest spelling that converts back to the same text
+	root := tibetanRootStack(stacks)
+	for _, form := range []struct{ dot, plus bool }{{false, false}, {true, false}, {false, true}, {true, true}} {
+		if form.dot && root == 0 {
+			continue
+		}
+		wylie := tibetanStacksToEWTS(stacks, root, form.dot, form.plus)
+		if uni, err := ewtsToUnicode(wylie); err == nil && uni == string(runes) {
+			return wylie
+		}
+	}
+	return tibetanStacksToEWTS(stacks, root, false, true)
+}
+
+// tibetanStacks splits the letters and marks of one syllable into stacks
+func tibetanStacks(runes []rune) []tibetanStack {
+	var stacks []tibetanStack
+	for _, r := range runes {
+		n := len(stacks)
+		switch {
+		case r >= 0x0f40 && r <= 0x0f6c || n == 0:
+			stacks = append(stacks, tibetanStack{})
+			n++
+			fallthrough
+		default:
+			st := &stacks[n-1]
+			if w, ok := tibetanLetters[r]; ok {
+				st.letters = appendEWTSLetter(st.letters, w)
+			} else if r == '\u0f39' && len(st.letters) > 0 {
+				// tsa-phru turns pha and ba into fa and va
+				last := &st.letters[len(st.letters)-1]
+				if fv, ok := map[string]string{"ph": "f", "b": "v"}[*last]; ok {
+					*last = fv
+				}
+			} else if w := tibetanMarks[r]; isEWTSFinal(w) {
+				st.finals += w
+			} else {
+				st.vowel = combineEWTSVowels(st.vowel, w)
+			}
+		}
+	}
+	return stacks
+}
+
+// appendEWTSLetter adds a letter to a stack, folding a subjoined ha into the
+// aspirate before it (gh, dh...) and ssa into kSh
+func appendEWTSLetter(letters []string, w string) []string {
+	if n := len(letters); n > 0 {
+		switch joined := letters[n-1] + w; joined {
+		case "gh", "Dh", "dh", "bh", "dzh", "kSh":
+			letters[n-1] = joined
+			return letters
+		}
+	}
+	return append(letters, w)
+}
+
+// combineEWTSVowels joins the a-chung sign with a following vowel sign, as
+// in ཱི (I), ཱུ (U) and ཱྀ (-I)
+func combineEWTSVowels(first, second string) string {
+	if first == "A" {
+		switch second {
+		case "i":
+			return "I"
+		case "u":
+			return "U"
+		case "-i":
+			return "-I"
+		}
+	}
+	return first + second
+}
+
+// tibetanRootStack returns the index of the stack that carries the vowel
+func tibetanRootStack(stacks []tibetanStack) int {
+	for i, st := range stacks {
+		if st.vowel == "" {
+			continue
+		}
+		// A vowel on a final a-chung (the 'i of ba'i) belongs to a syllable
+		// whose root is found as if it had no vowel
+		if i > 0 && len(st.letters) == 1 && st.letters[0] == "'" {
+			return tibetanRootWithoutVowel(stacks[:i+1])
+		}
+		return i
+	}
+	return tibetanRootWithoutVowel(stacks)
+}
+
+// tibetanRootWithoutVowel picks the root of a syllable with no vowel sign:
+// the second stack if the first is a prefix it allows and the second is not
+// a lone final suffix, else the first
+func tibetanRootWithoutVowel(stacks []tibetanStack) int {
+	if len(stacks) < 2 || !isEWTSPrefixFor(stacks[0], stacks[1]) {
+		return 0
+	}
+	if len(stacks) == 2 && len(stacks[1].letters) == 1 && ewtsSuffixes[stacks[1].letters[0]] {
+		return 0
+	}
+	return 1
+}
+
+// isEWTSPrefixFor reports whether stack p is a lone prefix letter that can
+// stand before stack root
+func isEWTSPrefixFor(p, root tibetanStack) bool {
+	if len(p.letters) != 1 || p.vowel != "" || len(root.letters) == 0 {
+		return false
+	}
+	prefix := p.letters[0]
+	if len(root.letters) >= 2 && takesSuperscript(root.letters[0], root.letters[1]) {
+		return prefix == "b"
+	}
+	return strings.Contains(ewtsPrefixRoots[prefix], " "+root.letters[0]+" ")
+}
+
+// isAmParticle reports whether stack i is the m or ng of an 'am or 'ang
+// particle closing the syllable, after an a-chung suffix
+func isAmParticle(stacks []tibetanStack, root, i int) bool {
+	if i != len(stacks)-1 || i-1 <= root || stacks[i].vowel != "" || stacks[i-1].vowel != "" {
+		return false
+	}
+	last, prev := stacks[i].letters, stacks[i-1].letters
+	return len(prev) == 1 && prev[0] == "'" && len(last) == 1 && (last[0] == "m" || last[0] == "ng")
+}
+
+// tibetanStacksToEWTS writes the stacks of a syllable with root as the
+// vowel-bearing stack, optionally marking the prefix with "." and joining
+// non-native stacks with "+"
+func tibetanStacksToEWTS(stacks []tibetanStack, root int, dot, plus bool) string {
+	// Letters after the root are suffixes only if there are at most two and
+	// each can be one; otherwise each is a Sanskrit syllable with its own a
+	suffixes := 0
+	nativeSuffixes := true
+	for _, st := range stacks[root+1:] {
+		if st.vowel != "" {
+			continue
+		}
+		suffixes++
+		if len(st.letters) != 1 || !ewtsSuffixes[st.letters[0]] {
+			nativeSuffixes = false
+		}
+	}
+	nativeSuffixes = nativeSuffixes && suffixes <= 2
+
+	var b strings.Builder
+	for i, st := range stacks {
+		letters := st.letters
+		if len(letters) == 1 && letters[0] == "a" {
+			// a-chen carries the vowel and is not written itself
+			letters = nil
+			if st.vowel == "" {
+				b.WriteString("a")
+			}
+		}
+		if nativeSuffixes && isAmParticle(stacks, root, i) {
+			// The particles 'am and 'ang keep their a: mi'am, dga'ang
+			b.WriteString("a")
+		}
+
+		if plus && len(letters) > 1 && !isNativeStack(letters) {
+			b.WriteString(strings.Join(letters, "+"))
+		} else {
+			b.WriteString(strings.Join(letters, ""))
+		}
+
+		switch {
+		case st.vowel != "":
+			b.WriteString(st.vowel)
+		case len(letters) == 0:
+		case i == root:
+			b.WriteString("a")
+		case i < root && !(i == root-1 && isEWTSPrefixFor(st, stacks[root])):
+			b.WriteString("a")
+		case i > root && !nativeSuffixes:
+			b.WriteString("a")
+		}
+		b.WriteString(st.finals)
+
+		if dot && i == root-1 {
+			b.WriteString(".")
+		}
+	}
+	return b.String()
+}
+
+// unicodeToHeadword converts a Tibetan-script headword to Wylie, dropping the
+// closing tsheg or shad
+func unicodeToHeadword(uni string) (string, bool) {
+	wylie := strings.TrimRight(unicodeToEWTS(strings.TrimSpace(uni)), " /")
+	if wylie == "" || strings.Contains(wylie, "[") {
+		return "", false
+	}
+	return wylie, true
+}
+
+// fillWylie completes a term's missing Wylie forms from Tibetan script, for
+// the headword and each related term. It returns how many forms were generated.
+func fillWylie(t *TermData) int {
+	generated := 0
+	if t.SearchTermWylie == "" && t.SearchTerm != "" {
+		if wylie, ok := unicodeToHeadword(t.SearchTerm); ok {
+			t.SearchTermWylie, t.SearchTermWylieGenerated = wylie, true
+			generated++
+		}
+	}
+	for i := range t.RelatedTerms {
+		rel := &t.RelatedTerms[i]
+		if rel.Wylie == "" && rel.Unicode != "" {
+			if wylie, ok := unicodeToHeadword(rel.Unicode); ok {
+				rel.Wylie, rel.WylieGenerated = wylie, true
+				generated++
+			}
+		}
+	}
+	return generated
+}
@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+func TestEWTSRoundTrip(t *testing.T) {
+	tests := []struct {
+		wylie, unicode string
+	}{
+		{"ka", "ཀ"},
+		{"bka'", "བཀའ"},
+		{"sgra", "སྒྲ"},
+		{"bsgrubs", "བསྒྲུབས"},
+		{"g.yag", "གཡག"},
+		{"dwags", "དྭགས"},
+		{"tshe", "ཚེ"},
+		{"dga'o", "དགའོ"},
+		{"mi'am", "མིའམ"},
+		{"mi'ang", "མིའང"},
+		{"pa'am", "པའམ"},
+		{"dga'am", "དགའམ"},
+		{"padma", "པདྨ"},
+		{"oM", "ཨོཾ"},
+		{"hU~M", "ཧཱུྃ"},
+		{"rgyal po", "རྒྱལ་པོ"},
+		{"o rgyan", "ཨོ་རྒྱན"},
+	}
+	for _, tt := range tests {
+		got, err := ewtsToUnicode(tt.wylie)
+		if err != nil {
+			t.Errorf("ewtsToUnicode(%q): %v", tt.wylie, err)
+		} else if got != tt.unicode {
+			t.Errorf("ewtsToUnicode(%q) = %q, want %q", tt.wylie, got, tt.unicode)
+		}
+		if got := unicodeToEWTS(tt.unicode); got != tt.wylie {
+			t.Errorf("unicodeToEWTS(%q) = %q, want %q", tt.unicode, got, tt.wylie)
+		}
+	}
+}
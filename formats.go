@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+)
+
+// Names of the built-in JSON input formats
+const (
+	formatPerTerm    = "per-term"
+	formatPaged      = "paged"
+	formatAggregated = "aggregated"
+	formatNDJSON     = "ndjson"
+)
+
+// jsonSniff describes the top level of a JSON document as far as its head goes
+type jsonSniff struct {
+	isObject  bool
+	truncated bool            // the head ended before the object did
+	keys      map[string]byte // first byte of each top-level value: '"', '{', '[', ...
+}
+
+// sniffJSONObject walks the top-level keys in head, noting what kind of value
+// each has without decoding it. A head cut off mid-document is fine.
+func sniffJSONObject(head []byte) jsonSniff {
+	sniff := jsonSniff{keys: make(map[string]byte)}
+	dec := json.NewDecoder(bytes.NewReader(head))
+
+	err := walkTopLevelObject(dec, func(key string) (bool, error) {
+		sniff.isObject = true
+		// Skip whitespace to find where the value starts
+		rest := head[dec.InputOffset():]
+		trimmed := bytes.TrimLeft(rest, " \t\r\n:")
+		if len(trimmed) > 0 {
+			sniff.keys[key] = trimmed[0]
+		}
+		return false, nil
+	})
+	if err != nil {
+		sniff.truncated = true
+	}
+	return sniff
+}
+
+// documentKeys returns the first byte of each top-level value of a JSON
+// document, like sniffJSONObject. When the head ran out before a searchTerm
+// or terms key, the document is reopened and scanned until one turns up.
+// The result is kept in doc for the other sources.
+func documentKeys(doc *TermDocument) map[string]byte {
+	if doc.keys != nil {
+		return doc.keys
+	}
+	sniff := sniffJSONObject(doc.Head)
+	doc.keys = sniff.keys
+	if headInconclusive(doc, sniff) && doc.reopen != nil {
+		if keys, err := scanTopLevelKeys(doc.reopen); err == nil {
+			doc.keys = keys
+		}
+	}
+	return doc.keys
+}
+
+// headInconclusive reports whether a JSON object ran past the sniffed head
+// without a searchTerm or terms key
+func headInconclusive(doc *TermDocument, sniff jsonSniff) bool {
+	_, hasSearchTerm := sniff.keys["searchTerm"]
+	_, hasTerms := sniff.keys["terms"]
+	return sniff.isObject && sniff.truncated && !hasSearchTerm && !hasTerms && len(doc.Head) == sniffSize
+}
+
+// perTermSource reads per-term files, where searchTerm is a plain string
+type perTermSource struct{}
+
+func (perTermSource) Name() string         { return formatPerTerm }
+func (perTermSource) Extensions() []string { return []string{".json"} }
+
+func (perTermSource) Detect(doc *TermDocument) bool {
+	return documentKeys(doc)["searchTerm"] == '"'
+}
+
+func (perTermSource) Read(doc *TermDocument, yield func(TermData) error) error {
+	data, err := ioutil.ReadAll(doc.Reader)
+	if err != nil {
+		return err
+	}
+	term, err := decodePerTerm(data)
+	if err != nil {
+		return err
+	}
+	return yield(term)
+}
+
+// pagedSource reads paged files, where searchTerm is a {wylie, unicode} object
+type pagedSource struct{}
+
+func (pagedSource) Name() string         { return formatPaged }
+func (pagedSource) Extensions() []string { return []string{".json"} }
+
+func (pagedSource) Detect(doc *TermDocument) bool {
+	return documentKeys(doc)["searchTerm"] == '{'
+}
+
+func (pagedSource) Read(doc *TermDocument, yield func(TermData) error) error {
+	data, err := ioutil.ReadAll(doc.Reader)
+	if err != nil {
+		return err
+	}
+	term, err := decodePaged(data)
+	if err != nil {
+		return err
+	}
+	return yield(term)
+}
+
+// aggregatedSource streams single-mode exports with a top-level "terms" object
+type aggregatedSource struct{}
+
+func (aggregatedSource) Name() string         { return formatAggregated }
+func (aggregatedSource) Extensions() []string { return []string{".json"} }
+
+// Detect accepts documents with a "terms" object. A document that cannot be
+// reopened and whose head ran out before a searchTerm or terms key is taken
+// to be an export with a large header.
+func (aggregatedSource) Detect(doc *TermDocument) bool {
+	keys := documentKeys(doc)
+	if keys["terms"] == '{' {
+		return true
+	}
+	return doc.reopen == nil && headInconclusive(doc, sniffJSONObject(doc.Head))
+}
+
+func (aggregatedSource) Read(doc *TermDocument, yield func(TermData) error) error {
+	_, err := streamAggregatedTerms(doc.Reader, yield)
+	return err
+}
+
+// pagedTermRecord is the "paged" per-file format, where `searchTerm` is an
+// object and `definitions` entries may include wylie/unicode objects
+type pagedTermRecord struct {
+	Timestamp  string `json:"timestamp"`
+	SearchTerm struct {
+		Wylie   string `json:"wylie"`
+		Unicode string `json:"unicode"`
+	} `json:"searchTerm"`
+	Definitions       map[string]interface{} `json:"definitions"`
+	RelatedTerms      []RelatedTerm          `json:"relatedTerms"`
+	DefinitionsCount  int                    `json:"definitionsCount"`
+	RelatedTermsCount int                    `json:"relatedTermsCount"`
+}
+
+// errNoSearchTerm is returned for JSON that parses but carries no headword
+var errNoSearchTerm = errors.New("no searchTerm found; not a per-term, paged or aggregated export")
+
+// decodePerTerm parses a per-term record where searchTerm is a plain string
+func decodePerTerm(data []byte) (TermData, error) {
+	var term TermData
+	if err := json.Unmarshal(data, &term); err != nil {
+		return TermData{}, err
+	}
+	if term.SearchTerm == "" {
+		return TermData{}, errNoSearchTerm
+	}
+	fillDefinitionText(&term)
+	return term, nil
+}
+
+// decodePaged parses a paged-style record (searchTerm is an object with wylie/unicode)
+func decodePaged(data []byte) (TermData, error) {
+	var paged pagedTermRecord
+	if err := json.Unmarshal(data, &paged); err != nil {
+		return TermData{}, err
+	}
+	if paged.SearchTerm.Unicode == "" && paged.SearchTerm.Wylie == "" {
+		return TermData{}, errNoSearchTerm
+	}
+
+	t := TermData{
+		SearchTerm:        paged.SearchTerm.Unicode,
+		SearchTermWylie:   paged.SearchTerm.Wylie,
+		Timestamp:         paged.Timestamp,
+		Definitions:       make(map[string]string),
+		RelatedTerms:      paged.RelatedTerms,
+		DefinitionsCount:  paged.DefinitionsCount,
+		RelatedTermsCount: paged.RelatedTermsCount,
+	}
+
+	for k, v := range paged.Definitions {
+		// Plain strings stay as they are; objects and lists keep their structure
+		if text, ok := v.(string); ok {
+			t.Definitions[k] = text
+			continue
+		}
+		if defs := parseDefinitionValue(v); len(defs) > 0 {
+			if t.StructuredDefinitions == nil {
+				t.StructuredDefinitions = make(map[string][]Definition)
+			}
+			t.StructuredDefinitions[k] = defs
+			t.Definitions[k] = flattenDefinitions(defs)
+		}
+	}
+
+	return t, nil
+}
+
+// pickDecodeError chooses the more useful of the per-term and paged failures.
+// A syntax error is reported as-is; a searchTerm type mismatch in one format
+// just means the file is the other format, so that format's error wins.
+func pickDecodeError(perTermErr, pagedErr error) error {
+	var syntaxErr *json.SyntaxError
+	if errors.As(perTermErr, &syntaxErr) {
+		return perTermErr
+	}
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(perTermErr, &typeErr) && typeErr.Field == "searchTerm" {
+		return pagedErr
+	}
+	return perTermErr
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Kindle lookup dictionaries (-kindle-dictionary).
+//
+// Kindle builds a lookup index from idx:entry elements in the content
+// files: idx:orth gives the headword and idx:iform further forms that look
+// it up. content.opf names the index and its languages in x-metadata. The
+// markup is for KindleGen and Kindle Previewer, which only read EPUB 2.
+
+// kindleNamespaces declares the idx and mbp prefixes on the html element
+const kindleNamespaces = ` xmlns:mbp="https://kindlegen.s3.amazonaws.com/AmazonKindlePublishingGuidelines.pdf" xmlns:idx="https://kindlegen.s3.amazonaws.com/AmazonKindlePublishingGuidelines.pdf"`
+
+// Lookup index names
+const (
+	kindleIndexTibetan = "tibetan"
+	kindleIndexEnglish = "english"
+)
+
+// kindleMetadata returns the x-metadata block of content.opf, or "" when
+// not writing a Kindle dictionary. The English-Tibetan edition looks up
+// English words; the others look up Tibetan.
+func (eg *EbookGenerator) kindleMetadata() string {
+	if !eg.kindleDict {
+		return ""
+	}
+	in, out, index := "bo", "en", kindleIndexTibetan
+	if eg.edition == editionEnglish {
+		in, out, index = "en", "bo", kindleIndexEnglish
+	}
+	return fmt.Sprintf(`
+    <x-metadata>
+      <DictionaryInLanguage>%s</DictionaryInLanguage>
+      <DictionaryOutLanguage>%s</DictionaryOutLanguage>
+      <DefaultLookupIndex>%s</DefaultLookupIndex>
+    </x-metadata>`, in, out, index)
+}
+
+// kindleEntry wraps an entry's content in idx:entry for the named index
+func kindleEntry(index, content string) string {
+	return fmt.Sprintf("    <idx:entry name=\"%s\" scriptable=\"yes\" spell=\"yes\">\n%s    </idx:entry>\n", index, content)
+}
+
+// kindleOrth marks display as the headword value, looked up also by forms
+func kindleOrth(value, display string, forms []string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, `<idx:orth value="%s">%s`, escapeXML(value), display)
+	if len(forms) > 0 {
+		b.WriteString("<idx:infl>")
+		for _, form := range forms {
+			fmt.Fprintf(&b, `<idx:iform value="%s"/>`, escapeXML(form))
+		}
+		b.WriteString("</idx:infl>")
+	}
+	b.WriteString("</idx:orth>")
+	return b.String()
+}
+
+// kindleHeadword returns the lookup value of a term and the other forms that
+// should find it: the Tibetan headword with and without its final tsheg, and
+// its Wylie
+func kindleHeadword(t TermData) (string, []string) {
+	tibetan := strings.TrimRight(strings.TrimSpace(t.SearchTerm), "་། ")
+	wylie := strings.TrimRight(strings.TrimSpace(t.SearchTermWylie), "/ ")
+
+	value := tibetan + "་"
+	candidates := []string{tibetan, wylie}
+	if tibetan == "" {
+		value, candidates = wylie, nil
+	}
+
+	var forms []string
+	seen := map[string]bool{value: true}
+	for _, form := range candidates {
+		if form != "" && !seen[form] {
+			seen[form] = true
+			forms = append(forms, form)
+		}
+	}
+	return value, forms
+}
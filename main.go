@@ -2,7 +2,6 @@ package main
 
 import (
 	"archive/zip"
-	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
@@ -10,7 +9,7 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
-	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -26,170 +25,129 @@ type TermData struct {
 	RelatedTerms       []RelatedTerm     `json:"relatedTerms"`
 	DefinitionsCount   int               `json:"definitionsCount"`
 	RelatedTermsCount  int               `json:"relatedTermsCount"`
+
+	// Structured form of Definitions, per source, where the input had one
+	StructuredDefinitions map[string][]Definition `json:"structuredDefinitions,omitempty"`
+
+	// Headword forms transliterated by the generator rather than read from input
+	SearchTermGenerated      bool `json:"-"`
+	SearchTermWylieGenerated bool `json:"-"`
 }
 
 // RelatedTerm represents a related term with both Wylie and Unicode forms
 type RelatedTerm struct {
 	Wylie   string `json:"wylie"`
 	Unicode string `json:"unicode"`
+
+	WylieGenerated   bool `json:"-"` // transliterated from Unicode
+	UnicodeGenerated bool `json:"-"` // transliterated from Wylie
 }
 
 // EbookGenerator creates an EPUB/AZW ebook from JSON term files
 type EbookGenerator struct {
-	inputDir   string
-	outputFile string
-	title      string
-	author     string
+	inputDir    string
+	inputDirs   []string // all input roots; inputDir is the first
+	include     []string // glob patterns a file must match to be read
+	exclude     []string // glob patterns that skip a file
+	inputFiles  []string // files found by the last ReadTermFiles call
+	outputFile  string
+	title       string
+	author      string
+	mergeMode   string            // merge policy for headwords found in several inputs
+	strict      bool              // fail if any input file is skipped or partly read
+	translit    bool              // fill in missing Tibetan-script and Wylie forms
+	sortOrder   string            // term order, see validateSortOrder
+	edition     string            // which volumes to write, see validateEdition
+	chapters    string            // how terms are grouped into files, see validateChapterGrouping
+	tocDepth    int               // levels of the table of contents, see validateTOCDepth
+	kindleDict  bool              // write Kindle lookup dictionary markup
+	sourceOrder sourceOrder       // order of dictionary sources in each entry
+	defScripts  definitionScripts // definition variants shown for each source
+	epubVersion string            // EPUB version to write, see validateEPUBVersion
+	sources     sourceFilter      // dictionary sources to include
+	keepEmpty   bool              // keep terms left with no definitions by the source filter
+	report      *ingestReport
 }
 
 // NewEbookGenerator creates a new ebook generator
 func NewEbookGenerator(inputDir, outputFile, title, author string) *EbookGenerator {
 	return &EbookGenerator{
-		inputDir:   inputDir,
-		outputFile: outputFile,
-		title:      title,
-		author:     author,
+		inputDir:    inputDir,
+		inputDirs:   []string{inputDir},
+		outputFile:  outputFile,
+		title:       title,
+		author:      author,
+		mergeMode:   mergeFirst,
+		translit:    true,
+		sortOrder:   orderTibetan,
+		edition:     editionTibetan,
+		chapters:    chaptersByLetter,
+		tocDepth:    tocLetters,
+		defScripts:  definitionScripts{scriptEnglish: true, scriptUnicode: true, scriptWylie: true},
+		epubVersion: epubVersion2,
 	}
 }
 
-// AggregatedTermExport represents the structure of a single-mode export file
-type AggregatedTermExport struct {
-	Timestamp  string                 `json:"timestamp"`
-	TotalTerms int                    `json:"totalTerms"`
-	Terms      map[string]TermData    `json:"terms"`
-	Summary    map[string]interface{} `json:"summary"`
-}
-
-// ReadTermFiles reads all JSON term files from the input directory
-// Supports both per-term format and aggregated single-file format
+// ReadTermFiles reads all JSON term files found under the input roots.
+// Each file is classified on its own as per-term, paged or aggregated, and
+// every file contributes to the same merged term set.
 func (eg *EbookGenerator) ReadTermFiles() ([]TermData, error) {
-	files, err := ioutil.ReadDir(eg.inputDir)
+	inputs := strings.Join(eg.inputDirs, ", ")
+	jsonFiles, err := findInputFiles(eg.inputDirs, eg.include, eg.exclude)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read directory %s: %w", eg.inputDir, err)
-	}
-
-	var terms []TermData
-	jsonFiles := []string{}
-
-	// Collect all JSON files
-	for _, file := range files {
-		if !file.IsDir() && strings.HasSuffix(file.Name(), ".json") {
-			jsonFiles = append(jsonFiles, filepath.Join(eg.inputDir, file.Name()))
-		}
+		return nil, err
 	}
+	eg.inputFiles = jsonFiles
 
 	if len(jsonFiles) == 0 {
-		return nil, fmt.Errorf("no JSON files found in %s", eg.inputDir)
+		return nil, fmt.Errorf("no JSON files found in %s", inputs)
 	}
 
-	// Try per-term format first. Also support "paged" per-file format where
-	// `searchTerm` is an object and `definitions` entries include wylie/unicode.
-	for _, path := range jsonFiles {
-		data, err := ioutil.ReadFile(path)
-		if err != nil {
-			continue
-		}
+	set := newTermSet(eg.mergeMode)
+	set.transliterate = eg.translit
+	report := newIngestReport()
+	eg.report = report
 
-		var term TermData
-		if err := json.Unmarshal(data, &term); err == nil && term.SearchTerm != "" {
-			terms = append(terms, term)
-			continue
-		}
-
-		// Try paged-style JSON (example: searchTerm is an object with wylie/unicode)
-		var paged struct {
-			Timestamp  string `json:"timestamp"`
-			SearchTerm struct {
-				Wylie   string `json:"wylie"`
-				Unicode string `json:"unicode"`
-			} `json:"searchTerm"`
-			Definitions       map[string]interface{} `json:"definitions"`
-			RelatedTerms      []RelatedTerm          `json:"relatedTerms"`
-			DefinitionsCount  int                    `json:"definitionsCount"`
-			RelatedTermsCount int                    `json:"relatedTermsCount"`
-		}
-
-		if err := json.Unmarshal(data, &paged); err == nil && (paged.SearchTerm.Unicode != "" || paged.SearchTerm.Wylie != "") {
-			// Build display string: Unicode ( Wylie )
-			displayTerm := paged.SearchTerm.Unicode
-			if displayTerm == "" {
-				displayTerm = paged.SearchTerm.Wylie
-			}
-			if paged.SearchTerm.Wylie != "" && paged.SearchTerm.Unicode != "" {
-				displayTerm = fmt.Sprintf("%s (%s)", paged.SearchTerm.Unicode, paged.SearchTerm.Wylie)
-			}
-
-			t := TermData{
-				SearchTerm:        paged.SearchTerm.Unicode,
-				SearchTermWylie:   paged.SearchTerm.Wylie,
-				Timestamp:         paged.Timestamp,
-				Definitions:       make(map[string]string),
-				RelatedTerms:      paged.RelatedTerms,
-				DefinitionsCount:  paged.DefinitionsCount,
-				RelatedTermsCount: paged.RelatedTermsCount,
-			}
-
-			for k, v := range paged.Definitions {
-				// Handle both string and object definitions
-				switch val := v.(type) {
-				case string:
-					t.Definitions[k] = val
-				case map[string]interface{}:
-					uni := ""
-					w := ""
-					if u, ok := val["unicode"].(string); ok {
-						uni = u
-					}
-					if wy, ok := val["wylie"].(string); ok {
-						w = wy
-					}
-					defDisplay := uni
-					if defDisplay == "" {
-						defDisplay = w
-					}
-					if uni != "" && w != "" {
-						defDisplay = fmt.Sprintf("%s (%s)", uni, w)
-					}
-					t.Definitions[k] = defDisplay
-				default:
-					t.Definitions[k] = fmt.Sprintf("%v", val)
-				}
-			}
+	for _, path := range jsonFiles {
+		readTermFile(path, set, report)
+	}
 
-			terms = append(terms, t)
-		}
+	if eg.strict && len(report.Issues) > 0 {
+		return nil, fmt.Errorf("strict mode: %d input file(s) could not be fully read", len(report.Issues))
 	}
 
-	// If no per-term files found, try aggregated format
+	terms := set.terms
 	if len(terms) == 0 {
-		for _, path := range jsonFiles {
-			data, err := ioutil.ReadFile(path)
-			if err != nil {
-				continue
-			}
+		return nil, fmt.Errorf("no valid term data found in %s (tried %s formats)", inputs, sourceNames())
+	}
 
-			var agg AggregatedTermExport
-			if err := json.Unmarshal(data, &agg); err == nil && len(agg.Terms) > 0 {
-				for key, term := range agg.Terms {
-					// Use the key as searchTerm if not already set
-					if term.SearchTerm == "" {
-						term.SearchTerm = key
-					}
-					terms = append(terms, term)
-				}
-				break // Only process first aggregated file
-			}
+	fmt.Printf("📦 Terms by format: %s\n", formatSummary(report.TermsByFormat))
+	if set.merged > 0 {
+		fmt.Printf("🔀 Merged %d duplicate headwords (%d conflicting definitions, policy: %s)\n", set.merged, set.conflicts, set.policy)
+	}
+	if eg.translit {
+		for i := range terms {
+			set.transliterated += fillWylie(&terms[i])
 		}
 	}
+	if set.transliterated > 0 {
+		fmt.Printf("🔤 Transliterated %d headwords and related terms between Wylie and Tibetan script\n", set.transliterated)
+	}
 
-	if len(terms) == 0 {
-		return nil, fmt.Errorf("no valid term data found in %s (tried per-term and aggregated formats)", eg.inputDir)
+	if eg.sources.active() {
+		var removed, dropped int
+		terms, removed, dropped = filterSources(terms, eg.sources, eg.keepEmpty)
+		fmt.Printf("🔎 Source filter removed %d definitions", removed)
+		if dropped > 0 {
+			fmt.Printf(" and %d terms left without definitions", dropped)
+		}
+		fmt.Println()
+		if len(terms) == 0 {
+			return nil, fmt.Errorf("no terms left after filtering dictionary sources")
+		}
 	}
 
-	// Sort terms alphabetically
-	sort.Slice(terms, func(i, j int) bool {
-		return terms[i].SearchTerm < terms[j].SearchTerm
-	})
+	sortTerms(terms, eg.sortOrder, eg.sourceOrder)
 
 	return terms, nil
 }
@@ -206,6 +164,21 @@ func (eg *EbookGenerator) GenerateEPUB(terms []TermData) error {
 	writer := zip.NewWriter(zipFile)
 	defer writer.Close()
 
+	// The English-Tibetan volume is built from this book's terms; in a book
+	// with both volumes its entries link to the term entries
+	chapters := groupTermChapters(terms, eg.chapters)
+	var sections []englishSection
+	if eg.edition != editionTibetan {
+		var links []string
+		if eg.edition == editionBoth {
+			links = termLinks(chapters)
+		}
+		sections = englishSections(buildReverseIndex(terms, eg.sourceOrder, links))
+	}
+	if eg.edition == editionEnglish {
+		chapters = nil
+	}
+
 	// Write mimetype file (uncompressed, must be first)
 	mimetypeFile, err := writer.Create("mimetype")
 	if err != nil {
@@ -219,22 +192,38 @@ func (eg *EbookGenerator) GenerateEPUB(terms []TermData) error {
 	}
 
 	// Write content.opf (package file)
-	if err := eg.writeContentOPF(writer, terms); err != nil {
+	if err := eg.writeContentOPF(writer, chapters, sections); err != nil {
 		return err
 	}
 
-	// Write table of contents
-	if err := eg.writeTOC(writer, terms); err != nil {
+	// Write table of contents, and for EPUB 3 the nav document
+	points := eg.navPoints(chapters, sections)
+	if err := eg.writeTOC(writer, points); err != nil {
 		return err
 	}
+	if eg.epubVersion == epubVersion3 {
+		if err := eg.writeNav(writer, points, eg.landmarks(chapters, sections)); err != nil {
+			return err
+		}
+	}
 
 	// Write title page
 	if err := eg.writeTitlePage(writer); err != nil {
 		return err
 	}
 
+	// Write the contents page with the letter index
+	if err := eg.writeContentsPage(writer, chapters, sections); err != nil {
+		return err
+	}
+
 	// Write term chapters
-	if err := eg.writeTermChapters(writer, terms); err != nil {
+	if err := eg.writeTermChapters(writer, chapters); err != nil {
+		return err
+	}
+
+	// Write the English-Tibetan volume
+	if err := eg.writeEnglishChapters(writer, sections); err != nil {
 		return err
 	}
 
@@ -245,6 +234,19 @@ func (eg *EbookGenerator) GenerateEPUB(terms []TermData) error {
 
 	fmt.Printf("✅ EPUB ebook created: %s\n", eg.outputFile)
 	fmt.Printf("📖 Contains %d terms\n", len(terms))
+	if len(sections) > 0 {
+		entries := 0
+		for _, section := range sections {
+			entries += len(section.Entries)
+		}
+		fmt.Printf("📖 English-Tibetan volume: %d English headwords\n", entries)
+	}
+	if eg.kindleDict {
+		fmt.Println("\n📌 Note: Build the Kindle dictionary with KindleGen or Kindle Previewer;")
+		fmt.Println("   Calibre does not keep the lookup index:")
+		fmt.Println("   - kindlegen input.epub -o output.mobi")
+		return nil
+	}
 	fmt.Println("\n📌 Note: EPUB is the open standard. To convert to AZW/AZW3:")
 	fmt.Println("   - Use Calibre: calibre-ebook -i input.epub -o output.azw3")
 	fmt.Println("   - Or use KindleGen: kindlegen input.epub -o output.mobi")
@@ -271,7 +273,7 @@ func (eg *EbookGenerator) writeContainerXML(writer *zip.Writer) error {
 }
 
 // writeContentOPF writes the OEBPS/content.opf (package) file
-func (eg *EbookGenerator) writeContentOPF(writer *zip.Writer, terms []TermData) error {
+func (eg *EbookGenerator) writeContentOPF(writer *zip.Writer, chapters []termChapter, sections []englishSection) error {
 	f, err := writer.Create("OEBPS/content.opf")
 	if err != nil {
 		return err
@@ -284,29 +286,60 @@ func (eg *EbookGenerator) writeContentOPF(writer *zip.Writer, terms []TermData)
     <dc:creator opf:role="aut">%s</dc:creator>
     <dc:language>bo-en</dc:language>
     <dc:date>%s</dc:date>
-    <dc:identifier id="uuid_id">tibetan-dict-ebook-%d</dc:identifier>
+    <dc:identifier id="uuid_id">tibetan-dict-ebook-%d</dc:identifier>%s
   </metadata>
   <manifest>
     <item id="ncx" href="toc.ncx" media-type="application/x-dtbncx+xml"/>
     <item id="style" href="style.css" media-type="text/css"/>
     <item id="font" href="fonts/DDC_Uchen-webfont.woff" media-type="application/x-font-woff"/>
-    <item id="title" href="title.xhtml" media-type="application/xhtml+xml"/>`, eg.title, eg.author, time.Now().Format("2006-01-02"), time.Now().Unix())
+    <item id="title" href="title.xhtml" media-type="application/xhtml+xml"/>
+    <item id="contents" href="toc.xhtml" media-type="application/xhtml+xml"/>`, escapeXML(eg.title), escapeXML(eg.author), time.Now().Format("2006-01-02"), time.Now().Unix(), eg.kindleMetadata())
+	if eg.epubVersion == epubVersion3 {
+		// EPUB 3 drops opf:role for a refining meta, requires dcterms:modified
+		// and marks the nav document in the manifest
+		now := time.Now().UTC()
+		opf = fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="uuid_id" xml:lang="en">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:title>%s</dc:title>
+    <dc:creator id="creator">%s</dc:creator>
+    <meta refines="#creator" property="role" scheme="marc:relators">aut</meta>
+    <dc:language>bo-en</dc:language>
+    <dc:date>%s</dc:date>
+    <dc:identifier id="uuid_id">tibetan-dict-ebook-%d</dc:identifier>
+    <meta property="dcterms:modified">%s</meta>
+  </metadata>
+  <manifest>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+    <item id="ncx" href="toc.ncx" media-type="application/x-dtbncx+xml"/>
+    <item id="style" href="style.css" media-type="text/css"/>
+    <item id="font" href="fonts/DDC_Uchen-webfont.woff" media-type="font/woff"/>
+    <item id="title" href="title.xhtml" media-type="application/xhtml+xml"/>
+    <item id="contents" href="toc.xhtml" media-type="application/xhtml+xml"/>`, escapeXML(eg.title), escapeXML(eg.author), now.Format("2006-01-02"), now.Unix(), now.Format("2006-01-02T15:04:05Z"))
+	}
 
 	// Add term chapters to manifest
-	for i := range terms {
-		opf += fmt.Sprintf("\n    <item id=\"chapter%d\" href=\"chapter%d.xhtml\" media-type=\"application/xhtml+xml\"/>", i+1, i+1)
+	for i, chapter := range chapters {
+		opf += fmt.Sprintf("\n    <item id=\"chapter%d\" href=\"%s\" media-type=\"application/xhtml+xml\"/>", i+1, chapter.File)
+	}
+	for i, section := range sections {
+		opf += fmt.Sprintf("\n    <item id=\"english%d\" href=\"%s\" media-type=\"application/xhtml+xml\"/>", i+1, section.File)
 	}
 
 	opf += `
   </manifest>
   <spine toc="ncx">
     <itemref idref="title"/>
+    <itemref idref="contents"/>
 `
 
 	// Add chapters to spine
-	for i := range terms {
+	for i := range chapters {
 		opf += fmt.Sprintf("    <itemref idref=\"chapter%d\"/>\n", i+1)
 	}
+	for i := range sections {
+		opf += fmt.Sprintf("    <itemref idref=\"english%d\"/>\n", i+1)
+	}
 
 	opf += `  </spine>
   <guide>
@@ -320,17 +353,17 @@ func (eg *EbookGenerator) writeContentOPF(writer *zip.Writer, terms []TermData)
 }
 
 // writeTOC writes the OEBPS/toc.ncx file
-func (eg *EbookGenerator) writeTOC(writer *zip.Writer, terms []TermData) error {
+func (eg *EbookGenerator) writeTOC(writer *zip.Writer, points []navPoint) error {
 	f, err := writer.Create("OEBPS/toc.ncx")
 	if err != nil {
 		return err
 	}
 
-	toc := `<?xml version="1.0" encoding="UTF-8"?>
+	toc := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
 <ncx xmlns="http://www.daisy.org/z3986/2005/ncx/" version="2005-1">
   <head>
     <meta name="dtb:uid" content="tibetan-dict-ebook"/>
-    <meta name="dtb:depth" content="1"/>
+    <meta name="dtb:depth" content="%d"/>
     <meta name="dtb:totalPageCount" content="0"/>
     <meta name="dtb:maxPageNumber" content="0"/>
   </head>
@@ -338,18 +371,11 @@ func (eg *EbookGenerator) writeTOC(writer *zip.Writer, terms []TermData) error {
     <text>Tibetan Dictionary</text>
   </docTitle>
   <navMap>
-    <navPoint id="title" playOrder="1">
-      <navLabel><text>Title</text></navLabel>
-      <content src="title.xhtml"/>
-    </navPoint>
-`
+`, navDepth(points))
 
-	for i, term := range terms {
-		toc += fmt.Sprintf("    <navPoint id=\"chapter%d\" playOrder=\"%d\">\n", i+1, i+2)
-		toc += fmt.Sprintf("      <navLabel><text>%s</text></navLabel>\n", escapeXML(term.SearchTerm))
-		toc += fmt.Sprintf("      <content src=\"chapter%d.xhtml\"/>\n", i+1)
-		toc += "    </navPoint>\n"
-	}
+	var navMap strings.Builder
+	writeNCXPoints(&navMap, points, "    ", &playOrder{})
+	toc += navMap.String()
 
 	toc += `  </navMap>
 </ncx>`
@@ -365,34 +391,37 @@ func (eg *EbookGenerator) writeTitlePage(writer *zip.Writer) error {
 		return err
 	}
 
-	title := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
-<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.1//EN" "http://www.w3.org/TR/xhtml11/DTD/xhtml11.dtd">
-<html xmlns="http://www.w3.org/1999/xhtml">
-  <head>
-    <title>%s</title>
-    <link rel="stylesheet" type="text/css" href="style.css"/>
-  </head>
-  <body>
-    <h1>%s</h1>
+	title := eg.xhtmlHead(eg.title, "titlepage") + fmt.Sprintf(`    <h1>%s</h1>
     <p class="author">By %s</p>
     <p class="timestamp">Generated: %s</p>
-    <p class="description">A Tibetan-English dictionary with definitions and related terms.</p>
+    <p class="description">%s</p>
   </body>
-</html>`, eg.title, eg.title, eg.author, time.Now().Format("January 2, 2006"))
+</html>`, escapeXML(eg.title), escapeXML(eg.author), time.Now().Format("January 2, 2006"), eg.editionDescription())
 
 	_, err = io.WriteString(f, title)
 	return err
 }
 
-// writeTermChapters writes individual term chapter files
-func (eg *EbookGenerator) writeTermChapters(writer *zip.Writer, terms []TermData) error {
-	for i, term := range terms {
-		chapterFile, err := writer.Create(fmt.Sprintf("OEBPS/chapter%d.xhtml", i+1))
+// editionDescription describes the book on the title page
+func (eg *EbookGenerator) editionDescription() string {
+	switch eg.edition {
+	case editionEnglish:
+		return "An English-Tibetan dictionary compiled from the definitions of a Tibetan-English dictionary."
+	case editionBoth:
+		return "A Tibetan-English dictionary with definitions and related terms, followed by an English-Tibetan index."
+	}
+	return "A Tibetan-English dictionary with definitions and related terms."
+}
+
+// writeTermChapters writes the term chapter files
+func (eg *EbookGenerator) writeTermChapters(writer *zip.Writer, chapters []termChapter) error {
+	for _, c := range chapters {
+		chapterFile, err := writer.Create("OEBPS/" + c.File)
 		if err != nil {
 			return err
 		}
 
-		chapter := eg.formatTermChapter(i+1, term)
+		chapter := eg.formatTermChapter(c)
 		if _, err := io.WriteString(chapterFile, chapter); err != nil {
 			return err
 		}
@@ -433,6 +462,25 @@ h2 {
   padding-bottom: 0.2em;
 }
 
+h3 {
+  font-size: 1.1em;
+  margin-top: 0.6em;
+  margin-bottom: 0.3em;
+  color: #555;
+  border-bottom: 1px solid #ddd;
+  padding-bottom: 0.2em;
+}
+
+.entry {
+  margin-bottom: 2em;
+}
+
+.headword {
+  font-size: 1.6em;
+  color: #333;
+  border-bottom: none;
+}
+
 .definition {
   margin-left: 1.5em;
   margin-bottom: 0.5em;
@@ -447,6 +495,21 @@ h2 {
   font-size: 0.95em;
 }
 
+.variant {
+  margin: 0.2em 0;
+}
+
+.sense {
+  font-weight: bold;
+  color: #555;
+}
+
+.note {
+  font-size: 0.9em;
+  font-style: italic;
+  color: #777;
+}
+
 .related-terms {
   margin-top: 1em;
   padding: 0.5em;
@@ -474,6 +537,32 @@ h2 {
   text-rendering: optimizeLegibility;
 }
 
+.reverse-entry {
+  margin-bottom: 0.8em;
+}
+
+.reverse-entry ul {
+  list-style-type: none;
+  padding-left: 1.5em;
+  margin: 0.2em 0;
+}
+
+.keyword {
+  border-bottom: none;
+  margin-bottom: 0.1em;
+}
+
+.letter-index {
+  font-size: 1.4em;
+  line-height: 2;
+  word-spacing: 0.6em;
+}
+
+.generated {
+  color: #666;
+  border-bottom: 1px dotted #aaa;
+}
+
 .metadata {
   font-size: 0.85em;
   color: #999;
@@ -506,57 +595,98 @@ h2 {
 	return err
 }
 
-// formatTermChapter formats a single term chapter as XHTML
-func (eg *EbookGenerator) formatTermChapter(chapterNum int, term TermData) string {
-	// Display term: Unicode first, fallback to Wylie
-	displayTerm := term.SearchTerm
-	if displayTerm == "" {
-		displayTerm = term.SearchTermWylie
+// formatTermChapter formats a chapter file of term entries as XHTML
+func (eg *EbookGenerator) formatTermChapter(chapter termChapter) string {
+	var b strings.Builder
+	b.WriteString(eg.xhtmlHead(chapter.title(), "bodymatter"))
+	if eg.kindleDict {
+		b.WriteString("    <mbp:frameset>\n")
+	}
+	if chapter.Letter != "" {
+		fmt.Fprintf(&b, "    <h1 class=\"letter\"><span class=\"unicode\">%s</span></h1>\n", escapeXML(chapter.Letter))
 	}
+	for i, term := range chapter.Terms {
+		b.WriteString(eg.formatTermEntry(chapter.First+i, term))
+	}
+	if eg.kindleDict {
+		b.WriteString("    </mbp:frameset>\n")
+	}
+	b.WriteString("  </body>\n</html>\n")
+	return b.String()
+}
 
+// formatTermEntry formats one term as an entry anchored by its number
+func (eg *EbookGenerator) formatTermEntry(termNum int, term TermData) string {
 	// Build the title and content line - format exactly like related terms: Unicode (Wylie)
 	var contentLine string
 	if term.SearchTerm != "" || term.SearchTermWylie != "" {
-		contentLine = fmt.Sprintf(`	<p><span class="unicode">%s</span> (<span class="wylie">%s</span>)</p>`, escapeXML(term.SearchTerm), escapeXML(term.SearchTermWylie))
-	}
-
-	chapter := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
-<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.1//EN" "http://www.w3.org/TR/xhtml11/DTD/xhtml11.dtd">
-<html xmlns="http://www.w3.org/1999/xhtml">
-	<head>
-		<title>%s</title>
-		<link rel="stylesheet" type="text/css" href="style.css"/>
-	</head>
-	<body>
-	<h1><span class="unicode">%s</span> (<span class="wylie">%s</span>)</h1>
+		contentLine = fmt.Sprintf(`    <p>%s</p>`, formatHeadword(term.SearchTerm, term.SearchTermWylie, term.SearchTermGenerated, term.SearchTermWylieGenerated))
+	}
+
+	headword := formatHeadword(term.SearchTerm, term.SearchTermWylie, term.SearchTermGenerated, term.SearchTermWylieGenerated)
+	if eg.kindleDict {
+		value, forms := kindleHeadword(term)
+		headword = kindleOrth(value, headword, forms)
+	}
+	chapter := fmt.Sprintf(`    <h2 class="headword">%s</h2>
 %s
-`, escapeXML(displayTerm), escapeXML(term.SearchTerm), escapeXML(term.SearchTermWylie), contentLine)
-
-	// Definitions
-	if term.DefinitionsCount > 0 {
-		chapter += "    <h2>Definitions</h2>\n"
-		for dictName, def := range term.Definitions {
-			if def != "" {
-				formattedDef := formatDefinitionText(def)
-				chapter += fmt.Sprintf(`    <div class="definition">
-      <div class="dict-name">%s</div>
-      <p>%s</p>
-    </div>
-`, escapeXML(dictName), escapeXML(formattedDef))
-			}
+`, headword, contentLine)
+
+	// Definitions: per source, the English text with its Tibetan-script and
+	// Wylie variants, as chosen by -definition-scripts. Structured definitions
+	// are filtered part by part.
+	var english, unicodeDefs, wylieDefs map[string]string
+	if eg.defScripts[scriptEnglish] {
+		english = term.Definitions
+	}
+	if eg.defScripts[scriptUnicode] {
+		unicodeDefs = term.DefinitionsUnicode
+	}
+	if eg.defScripts[scriptWylie] {
+		wylieDefs = term.DefinitionsWylie
+	}
+	structuredSources := make(map[string]string)
+	for dictName := range term.StructuredDefinitions {
+		structuredSources[dictName] = term.Definitions[dictName]
+	}
+	var definitions string
+	rendered := 0
+	for _, dictName := range eg.sourceOrder.sources(english, unicodeDefs, wylieDefs, structuredSources) {
+		var body string
+		if structured := term.StructuredDefinitions[dictName]; len(structured) > 0 {
+			body = formatStructuredDefinitions(structured, eg.defScripts)
+		} else if text := english[dictName]; text != "" {
+			body = fmt.Sprintf("      <p>%s</p>\n", escapeXML(formatDefinitionText(text)))
+		}
+		if text := unicodeDefs[dictName]; text != "" && text != english[dictName] {
+			body += formatDefinitionVariant(scriptUnicode, text)
+		}
+		if text := wylieDefs[dictName]; text != "" && text != english[dictName] {
+			body += formatDefinitionVariant(scriptWylie, text)
 		}
+		if body == "" {
+			continue
+		}
+		rendered++
+		definitions += fmt.Sprintf(`    <div class="definition">
+      <div class="dict-name">%s</div>
+%s    </div>
+`, escapeXML(dictName), body)
+	}
+	if definitions != "" {
+		chapter += "    <h3>Definitions</h3>\n" + definitions
 	}
 
 	// Related terms
 	if term.RelatedTermsCount > 0 {
 		chapter += `    <div class="related-terms">
-      <h2>Related Terms</h2>
+      <h3>Related Terms</h3>
       <ul>
 `
 		for _, rt := range term.RelatedTerms {
 			if rt.Unicode != "" || rt.Wylie != "" {
-				chapter += fmt.Sprintf(`        <li><span class="unicode">%s</span> (<span class="wylie">%s</span>)</li>
-`, escapeXML(rt.Unicode), escapeXML(rt.Wylie))
+				chapter += fmt.Sprintf(`        <li>%s</li>
+`, formatHeadword(rt.Unicode, rt.Wylie, rt.UnicodeGenerated, rt.WylieGenerated))
 			}
 		}
 		chapter += `      </ul>
@@ -564,15 +694,32 @@ func (eg *EbookGenerator) formatTermChapter(chapterNum int, term TermData) strin
 `
 	}
 
-	// Metadata footer
-	chapter += fmt.Sprintf(`    <div class="metadata">
+	// Metadata footer; the definition count is of the sources shown above
+	chapter += fmt.Sprintf(`    <%s class="metadata">
       <p>Term #%d | Definitions: %d | Related: %d</p>
-    </div>
-  </body>
-</html>
-`, chapterNum, term.DefinitionsCount, term.RelatedTermsCount)
+    </%s>
+`, eg.footerTag(), termNum, rendered, term.RelatedTermsCount, eg.footerTag())
+
+	if eg.kindleDict {
+		chapter = kindleEntry(kindleIndexTibetan, chapter)
+	}
+	return fmt.Sprintf("    <div class=\"entry\" id=\"%s\">\n%s    </div>\n", termAnchor(termNum), chapter)
+}
 
-	return chapter
+// formatHeadword renders a term as Unicode (Wylie). Forms the generator
+// transliterated get the extra class "generated" so they can be told apart.
+func formatHeadword(unicode, wylie string, unicodeGenerated, wylieGenerated bool) string {
+	return fmt.Sprintf(`<span class="%s">%s</span> (<span class="%s">%s</span>)`,
+		scriptClass("unicode", unicodeGenerated), escapeXML(unicode),
+		scriptClass("wylie", wylieGenerated), escapeXML(wylie))
+}
+
+// scriptClass returns the CSS class for a script span
+func scriptClass(class string, generated bool) string {
+	if generated {
+		return class + " generated"
+	}
+	return class
 }
 
 // embedFont embeds the DDC Uchen Tibetan font in the EPUB
@@ -634,54 +781,158 @@ func formatDefinitionText(def string) string {
 	return strings.TrimSpace(def)
 }
 
-// calculateJSONFilesSize calculates the total size of all JSON files in a directory
-func calculateJSONFilesSize(dirPath string) int64 {
+// calculateJSONFilesSize calculates the total size of the given JSON files
+func calculateJSONFilesSize(paths []string) int64 {
 	var totalSize int64
 
-	files, err := ioutil.ReadDir(dirPath)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "⚠️  Warning: Could not read directory for size calculation: %v\n", err)
-		return 0
-	}
-
-	for _, file := range files {
-		if !file.IsDir() && strings.HasSuffix(file.Name(), ".json") {
-			totalSize += file.Size()
+	for _, path := range paths {
+		if path == stdinInput {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "⚠️  Warning: Could not stat %s for size calculation: %v\n", path, err)
+			continue
 		}
+		totalSize += info.Size()
 	}
 
 	return totalSize
 }
 
 func main() {
-	inputDir := flag.String("input", "./data", "Input directory containing JSON term files")
+	var inputDirs, includes, excludes stringListFlag
+	flag.Var(&inputDirs, "input", "Input directory, archive (.zip, .tar.gz) or .json.gz file with term data, or - for stdin (repeatable, default ./data)")
+	flag.Var(&includes, "include", "Only read input files matching this glob, relative to the input root; ** matches any depth (repeatable)")
+	flag.Var(&excludes, "exclude", "Skip input files matching this glob (repeatable)")
 	paged := flag.Bool("paged", false, "Read per-page JSON files from the 'paged' subdirectory and treat each file as one ebook page")
 	outputFile := flag.String("output", "tibetan-dictionary.epub", "Output EPUB/AZW file")
 	title := flag.String("title", "Tibetan-English Dictionary", "Ebook title")
 	author := flag.String("author", "Tibetan Dictionary Project", "Ebook author")
+	mergeMode := flag.String("merge-conflicts", mergeFirst, "How to merge differing definitions of a headword found in several inputs: first, last or join")
+	strict := flag.Bool("strict", false, "Fail if any input file is skipped or only partly read")
+	reportPath := flag.String("report", "", "Also write the ingestion report as JSON to this file")
+	csvColumns := flag.String("columns", "", "Column mapping for CSV/TSV glossaries, e.g. wylie,unicode,Hopkins 2015:definition,related (default: read from the header row)")
+	csvHeader := flag.Bool("csv-header", true, "CSV/TSV glossaries start with a header row")
+	relatedSep := flag.String("related-sep", ";", "Separator between related terms in a CSV/TSV cell")
+	transliterate := flag.Bool("transliterate", true, "Fill in missing Tibetan-script and Wylie forms of headwords and related terms by transliterating the other form (EWTS)")
+	edition := flag.String("edition", editionTibetan, "Volumes to write: tibetan (Tibetan-English), english (English-Tibetan from the definitions) or both (in one book, linked)")
+	sourceOrderList := flag.String("source-order", "", "Comma-separated dictionary sources to show first, in this order, e.g. \"Hopkins 2015,Rangjung Yeshe\"; globs allowed, the rest follow alphabetically")
+	var onlySources, excludeSources stringListFlag
+	flag.Var(&onlySources, "sources", "Only include definitions from these dictionary sources, comma-separated; globs allowed (repeatable)")
+	flag.Var(&excludeSources, "exclude-sources", "Drop definitions from these dictionary sources, comma-separated; globs allowed (repeatable)")
+	keepEmpty := flag.Bool("keep-empty-terms", false, "Keep terms that have no definitions left after -sources/-exclude-sources")
+	defScriptList := flag.String("definition-scripts", allDefinitionScripts, "Comma-separated definition variants to show for each source: english, unicode (Tibetan script) and wylie")
+	chapterGrouping := flag.String("chapters", chaptersByLetter, "Chapter files of the Tibetan-English volume: letter (one per root letter), term (one per term) or a number of entries per file")
+	tocDepth := flag.Int("toc-depth", tocLetters, "Table of contents levels: 1 (one entry per term), 2 (terms under their root letter) or 3 (and under their first two syllables)")
+	kindleDict := flag.Bool("kindle-dictionary", false, "Write a Kindle lookup dictionary (idx:entry markup and dictionary languages in content.opf) for KindleGen or Kindle Previewer; EPUB 2 only")
+	epubVersion := flag.String("epub-version", epubVersion2, "EPUB version to write: 2, or 3 (adds a nav document and HTML5 chapters; toc.ncx is kept)")
+	sortOrder := flag.String("sort", orderTibetan, "Term order: tibetan (dictionary order), wylie, english (first gloss), input (as read) or definitions (most first)")
 	flag.Parse()
 
+	if err := validateMergePolicy(*mergeMode); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err := validateSortOrder(*sortOrder); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err := validateEdition(*edition); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err := validateEPUBVersion(*epubVersion); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err := validateChapterGrouping(*chapterGrouping); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err := validateTOCDepth(*tocDepth); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Root letters only come in runs in Tibetan order; other orders get
+	// fixed-size chapter files and a flat table of contents
+	if *sortOrder != orderTibetan {
+		if *chapterGrouping == chaptersByLetter {
+			*chapterGrouping = strconv.Itoa(unsortedChapterSize)
+		}
+		*tocDepth = tocFlat
+	}
+
+	if *kindleDict && *epubVersion != epubVersion2 {
+		fmt.Fprintf(os.Stderr, "❌ Error: -kindle-dictionary needs -epub-version %s, the format KindleGen reads\n", epubVersion2)
+		os.Exit(1)
+	}
+
+	defScripts, err := parseDefinitionScripts(*defScriptList)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err := defaultCSVSource.Configure(*csvColumns, *relatedSep, *csvHeader); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if len(inputDirs) == 0 {
+		inputDirs = stringListFlag{"./data"}
+	}
+
 	fmt.Println("📚 Tibetan Dictionary Ebook Generator")
 	fmt.Println("=====================================")
-	fmt.Printf("📁 Input directory: %s\n", *inputDir)
+	fmt.Printf("📁 Input directory: %s\n", inputDirs.String())
 	fmt.Printf("📝 Output file base: %s\n", *outputFile)
 	fmt.Printf("📏 Target ebook size: 29-32 MB per part\n")
 
-	// Check if input directory exists
-	if _, err := os.Stat(*inputDir); err != nil {
-		fmt.Fprintf(os.Stderr, "❌ Error: input directory not found: %s\n", *inputDir)
-		os.Exit(1)
+	// Check if inputs exist
+	for _, dir := range inputDirs {
+		if dir == stdinInput {
+			continue
+		}
+		if _, err := os.Stat(dir); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ Error: input not found: %s\n", dir)
+			os.Exit(1)
+		}
 	}
 
 	fmt.Println("⏳ Reading term files...")
-	// If paged mode requested, read JSON files from the `paged` subdirectory
-	inputPath := *inputDir
-	if *paged {
-		inputPath = filepath.Join(inputPath, "paged")
+	// If paged mode requested, read JSON files from each root's `paged` subdirectory
+	inputPaths := make([]string, len(inputDirs))
+	for i, dir := range inputDirs {
+		inputPaths[i] = dir
+		if *paged && dir != stdinInput {
+			inputPaths[i] = filepath.Join(dir, "paged")
+		}
 	}
+	inputPath := inputPaths[0]
 	gen := NewEbookGenerator(inputPath, *outputFile, *title, *author)
+	gen.inputDirs = inputPaths
+	gen.include = includes
+	gen.exclude = excludes
+	gen.mergeMode = *mergeMode
+	gen.strict = *strict
+	gen.translit = *transliterate
+	gen.sortOrder = *sortOrder
+	gen.sourceOrder = parseSourceOrder(*sourceOrderList)
+	gen.sources = sourceFilter{
+		include: parseSourceOrder(strings.Join(onlySources, ",")),
+		exclude: parseSourceOrder(strings.Join(excludeSources, ",")),
+	}
+	gen.keepEmpty = *keepEmpty
 	terms, err := gen.ReadTermFiles()
 	if err != nil {
+		writeIngestReport(gen.report, *reportPath)
 		fmt.Fprintf(os.Stderr, "❌ Error: %v\n", err)
 		os.Exit(1)
 	}
@@ -689,7 +940,7 @@ func main() {
 	fmt.Printf("✓ Found %d terms\n", len(terms))
 
 	// Calculate total size of JSON files to determine number of parts
-	totalSize := calculateJSONFilesSize(inputPath)
+	totalSize := calculateJSONFilesSize(gen.inputFiles)
 
 	// Target size per ebook: 30MB (middle of 29-32MB range)
 	const targetSize int64 = 30 * 1024 * 1024 // 30MB in bytes
@@ -699,6 +950,11 @@ func main() {
 	if numParts < 1 {
 		numParts = 1
 	}
+	// A lookup dictionary is one book: parts would each claim the same index
+	if *kindleDict && numParts > 1 {
+		fmt.Printf("📊 Kindle lookup dictionary: writing one book instead of %d parts\n", numParts)
+		numParts = 1
+	}
 
 	fmt.Printf("📊 Total JSON file size: %.2f MB\n", float64(totalSize)/(1024*1024))
 	fmt.Printf("📊 Target size per ebook: 29-32 MB\n")
@@ -743,6 +999,14 @@ func main() {
 		}
 
 		gen := NewEbookGenerator(inputPath, outputPath, partTitle, *author)
+		gen.edition = *edition
+		gen.sortOrder = *sortOrder
+		gen.sourceOrder = parseSourceOrder(*sourceOrderList)
+		gen.defScripts = defScripts
+		gen.epubVersion = *epubVersion
+		gen.chapters = *chapterGrouping
+		gen.tocDepth = *tocDepth
+		gen.kindleDict = *kindleDict
 
 		fmt.Printf("⏳ Generating Part %d EPUB ebook (%d terms)...\n", i+1, len(parts[i]))
 		if err := gen.GenerateEPUB(parts[i]); err != nil {
@@ -750,6 +1014,24 @@ func main() {
 			os.Exit(1)
 		}
 	}
+
+	writeIngestReport(gen.report, *reportPath)
+}
+
+// writeIngestReport prints the ingestion report and saves it as JSON if a path is given
+func writeIngestReport(report *ingestReport, path string) {
+	if report == nil {
+		return
+	}
+	report.Print(os.Stdout)
+	if path == "" {
+		return
+	}
+	if err := report.WriteJSON(path); err != nil {
+		fmt.Fprintf(os.Stderr, "⚠️  Warning: could not write report %s: %v\n", path, err)
+		return
+	}
+	fmt.Printf("📋 Report written to %s\n", path)
 }
 
 func min(a, b int) int {
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Merge policies for a dictionary source that has different text in two inputs
+const (
+	mergeFirst = "first" // keep the text seen first
+	mergeLast  = "last"  // later inputs override earlier ones
+	mergeJoin  = "join"  // keep both, separated by "; "
+)
+
+// validateMergePolicy checks a -merge-conflicts value
+func validateMergePolicy(policy string) error {
+	switch policy {
+	case mergeFirst, mergeLast, mergeJoin:
+		return nil
+	}
+	return fmt.Errorf("unknown merge policy %q (want %s, %s or %s)", policy, mergeFirst, mergeLast, mergeJoin)
+}
+
+// termSet collects terms from any number of inputs, merging entries that
+// share a headword. Terms keep the order in which they were first seen.
+type termSet struct {
+	policy         string
+	terms          []TermData
+	index          map[string]int
+	merged         int  // headwords seen more than once
+	conflicts      int  // source entries whose text differed between inputs
+	transliterate  bool // fill in missing Tibetan-script forms from Wylie
+	transliterated int  // Tibetan-script forms generated from Wylie
+}
+
+// newTermSet creates an empty term set using the given merge policy
+func newTermSet(policy string) *termSet {
+	if policy == "" {
+		policy = mergeFirst
+	}
+	return &termSet{
+		policy: policy,
+		index:  make(map[string]int),
+	}
+}
+
+// headwordKeys returns the keys used to recognise the same headword across
+// inputs: its Unicode and Wylie forms, without the trailing tsheg or shad that
+// some exports add and others omit. Either key may be empty.
+func headwordKeys(t TermData) (string, string) {
+	unicodeKey := strings.TrimRight(strings.TrimSpace(t.SearchTerm), "་། ")
+	wylieKey := strings.TrimRight(strings.TrimSpace(t.SearchTermWylie), "/ ")
+	if unicodeKey != "" {
+		unicodeKey = "u:" + unicodeKey
+	}
+	if wylieKey != "" {
+		wylieKey = "w:" + wylieKey
+	}
+	return unicodeKey, wylieKey
+}
+
+// find returns the index of the entry with the same headword as t. A Wylie
+// match only counts if the entries do not disagree on the Unicode form, so
+// Wylie-only inputs such as StarDict merge with full CLI exports.
+func (ts *termSet) find(t TermData) (int, bool) {
+	unicodeKey, wylieKey := headwordKeys(t)
+	if i, ok := ts.index[unicodeKey]; ok && unicodeKey != "" {
+		return i, true
+	}
+	if i, ok := ts.index[wylieKey]; ok && wylieKey != "" {
+		if ts.terms[i].SearchTerm == "" || t.SearchTerm == "" {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// register indexes entry i under its headword keys that are not yet taken
+func (ts *termSet) register(i int) {
+	unicodeKey, wylieKey := headwordKeys(ts.terms[i])
+	for _, key := range []string{unicodeKey, wylieKey} {
+		if _, taken := ts.index[key]; key != "" && !taken {
+			ts.index[key] = i
+		}
+	}
+}
+
+// add inserts a term, merging it into an existing entry with the same headword
+func (ts *termSet) add(t TermData) {
+	if ts.transliterate {
+		ts.transliterated += fillTibetanScript(&t)
+	}
+
+	i, ok := ts.find(t)
+	if !ok {
+		ts.terms = append(ts.terms, t)
+		ts.register(len(ts.terms) - 1)
+		return
+	}
+
+	ts.merged++
+	existing := &ts.terms[i]
+	// Headword forms from the input replace transliterated ones
+	if existing.SearchTerm == "" || existing.SearchTermGenerated && t.SearchTerm != "" && !t.SearchTermGenerated {
+		existing.SearchTerm, existing.SearchTermGenerated = t.SearchTerm, t.SearchTermGenerated
+	}
+	if existing.SearchTermWylie == "" || existing.SearchTermWylieGenerated && t.SearchTermWylie != "" && !t.SearchTermWylieGenerated {
+		existing.SearchTermWylie, existing.SearchTermWylieGenerated = t.SearchTermWylie, t.SearchTermWylieGenerated
+	}
+	if existing.Timestamp == "" {
+		existing.Timestamp = t.Timestamp
+	}
+
+	var before map[string]string
+	if len(existing.StructuredDefinitions) > 0 || len(t.StructuredDefinitions) > 0 {
+		before = make(map[string]string, len(existing.Definitions))
+		for source, text := range existing.Definitions {
+			before[source] = text
+		}
+	}
+	existing.Definitions = ts.mergeDefinitions(existing.Definitions, t.Definitions)
+	mergeStructuredDefinitions(existing, before, t)
+	existing.DefinitionsWylie = ts.mergeDefinitions(existing.DefinitionsWylie, t.DefinitionsWylie)
+	existing.DefinitionsUnicode = ts.mergeDefinitions(existing.DefinitionsUnicode, t.DefinitionsUnicode)
+	existing.RelatedTerms = mergeRelatedTerms(existing.RelatedTerms, t.RelatedTerms)
+
+	existing.DefinitionsCount = len(existing.Definitions)
+	existing.RelatedTermsCount = len(existing.RelatedTerms)
+	ts.register(i)
+}
+
+// mergeDefinitions unions two source-to-text maps, resolving differing text
+// for the same source according to the set's policy
+func (ts *termSet) mergeDefinitions(dst, src map[string]string) map[string]string {
+	if len(src) == 0 {
+		return dst
+	}
+	if dst == nil {
+		dst = make(map[string]string, len(src))
+	}
+
+	for source, text := range src {
+		old, ok := dst[source]
+		if !ok || old == "" {
+			dst[source] = text
+			continue
+		}
+		if text == "" || text == old {
+			continue
+		}
+		if ts.policy == mergeJoin && strings.Contains(old, text) {
+			continue
+		}
+
+		ts.conflicts++
+		switch ts.policy {
+		case mergeLast:
+			dst[source] = text
+		case mergeJoin:
+			dst[source] = old + "; " + text
+		}
+	}
+
+	return dst
+}
+
+// mergeRelatedTerms appends the related terms from src that are not already
+// in dst. Terms are compared by text, whether transliterated or not.
+func mergeRelatedTerms(dst, src []RelatedTerm) []RelatedTerm {
+	key := func(rt RelatedTerm) RelatedTerm { return RelatedTerm{Wylie: rt.Wylie, Unicode: rt.Unicode} }
+	seen := make(map[RelatedTerm]bool, len(dst))
+	for _, rt := range dst {
+		seen[key(rt)] = true
+	}
+	for _, rt := range src {
+		if !seen[key(rt)] {
+			seen[key(rt)] = true
+			dst = append(dst, rt)
+		}
+	}
+	return dst
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// EPUB versions for -epub-version
+const (
+	epubVersion2 = "2" // OPF 2.0, toc.ncx and XHTML 1.1
+	epubVersion3 = "3" // OPF 3.0, nav.xhtml and HTML5; toc.ncx is kept for older readers
+)
+
+// validateEPUBVersion checks an -epub-version value
+func validateEPUBVersion(version string) error {
+	switch version {
+	case epubVersion2, epubVersion3:
+		return nil
+	}
+	return fmt.Errorf("unknown EPUB version %q (want %s or %s)", version, epubVersion2, epubVersion3)
+}
+
+// Table of contents depths for -toc-depth
+const (
+	tocFlat      = 1 // one entry per term
+	tocLetters   = 2 // terms under their root letter
+	tocSyllables = 3 // terms under their root letter and first two syllables
+)
+
+// validateTOCDepth checks a -toc-depth value
+func validateTOCDepth(depth int) error {
+	if depth < tocFlat || depth > tocSyllables {
+		return fmt.Errorf("unknown table of contents depth %d (want %d, %d or %d)", depth, tocFlat, tocLetters, tocSyllables)
+	}
+	return nil
+}
+
+// navPoint is an entry of the table of contents. The same entries are
+// written to toc.ncx and, for EPUB 3, to the nav document.
+type navPoint struct {
+	ID       string
+	Label    string
+	Src      string
+	Children []navPoint
+}
+
+// landmark is an entry of the EPUB 3 landmarks nav
+type landmark struct {
+	Type  string // epub:type, e.g. bodymatter
+	Label string
+	Src   string
+}
+
+// navPoints lists the table of contents: the title and contents pages, the
+// term entries nested as -toc-depth asks, and each letter of the
+// English-Tibetan volume
+func (eg *EbookGenerator) navPoints(chapters []termChapter, sections []englishSection) []navPoint {
+	points := []navPoint{
+		{ID: "title", Label: "Title", Src: "title.xhtml"},
+		{ID: "contents", Label: "Contents", Src: "toc.xhtml"},
+	}
+	points = append(points, termNavPoints(chapters, eg.tocDepth)...)
+	for i, section := range sections {
+		points = append(points, navPoint{
+			ID:    fmt.Sprintf("english%d", i+1),
+			Label: "English: " + section.Letter,
+			Src:   section.File,
+		})
+	}
+	return points
+}
+
+// termNavPoints lists the term entries. From depth 2 they are nested under
+// their root letter, and at depth 3 terms sharing their first two syllables
+// are nested again under those syllables.
+func termNavPoints(chapters []termChapter, depth int) []navPoint {
+	var letters []navPoint
+	var terms []navPoint
+	for _, chapter := range chapters {
+		for i, term := range chapter.Terms {
+			anchor := termAnchor(chapter.First + i)
+			point := navPoint{ID: anchor, Label: termLabel(term), Src: chapter.File + "#" + anchor}
+			if depth == tocFlat {
+				terms = append(terms, point)
+				continue
+			}
+
+			// A new letter starts when the root letter changes; a letter
+			// chapter's first entry links to the top of the chapter
+			letter := termRootLetter(term)
+			if n := len(letters); n == 0 || letters[n-1].Label != letter {
+				src := point.Src
+				if i == 0 && chapter.Letter == letter {
+					src = chapter.File
+				}
+				letters = append(letters, navPoint{ID: fmt.Sprintf("letter%d", n+1), Label: letter, Src: src})
+			}
+			current := &letters[len(letters)-1]
+			current.Children = append(current.Children, point)
+		}
+	}
+	if depth == tocFlat {
+		return terms
+	}
+
+	if depth == tocSyllables {
+		prefixes := make(map[string]string) // term anchor -> first two syllables
+		for _, chapter := range chapters {
+			for i, term := range chapter.Terms {
+				prefixes[termAnchor(chapter.First+i)] = leadingSyllables(term, 2)
+			}
+		}
+		groups := 0
+		for i := range letters {
+			letters[i].Children = groupBySyllables(letters[i].Children, prefixes, &groups)
+		}
+	}
+	return letters
+}
+
+// groupBySyllables nests runs of term points whose headwords share their
+// first two syllables, given by prefixes for each term anchor, under a point
+// for those syllables. Terms that share them with no other stay where they are.
+func groupBySyllables(points []navPoint, prefixes map[string]string, groups *int) []navPoint {
+	var grouped []navPoint
+	for start := 0; start < len(points); {
+		prefix := prefixes[points[start].ID]
+		end := start + 1
+		for end < len(points) && prefixes[points[end].ID] == prefix {
+			end++
+		}
+		if end-start == 1 || prefix == "" {
+			grouped = append(grouped, points[start:end]...)
+		} else {
+			*groups++
+			grouped = append(grouped, navPoint{
+				ID:       fmt.Sprintf("syllables%d", *groups),
+				Label:    prefix,
+				Src:      points[start].Src,
+				Children: points[start:end],
+			})
+		}
+		start = end
+	}
+	return grouped
+}
+
+// leadingSyllables returns the first n syllables of a term's headword, in
+// Tibetan script (ending in a tsheg) if it has it and in Wylie otherwise
+func leadingSyllables(t TermData, n int) string {
+	if uni := termTibetan(t); uni != "" {
+		syllables := strings.FieldsFunc(uni, func(r rune) bool { return r == '\u0f0b' || r == '\u0f0d' || unicode.IsSpace(r) })
+		if len(syllables) > n {
+			syllables = syllables[:n]
+		}
+		return strings.Join(syllables, "\u0f0b") + "\u0f0b"
+	}
+	syllables := strings.Fields(t.SearchTermWylie)
+	if len(syllables) > n {
+		syllables = syllables[:n]
+	}
+	return strings.Join(syllables, " ")
+}
+
+// landmarks lists the landmarks of the book: the title page, the table of
+// contents and where each volume starts
+func (eg *EbookGenerator) landmarks(chapters []termChapter, sections []englishSection) []landmark {
+	marks := []landmark{
+		{Type: "titlepage", Label: "Title Page", Src: "title.xhtml"},
+		{Type: "toc", Label: "Table of Contents", Src: "toc.xhtml"},
+	}
+	if len(chapters) > 0 {
+		marks = append(marks, landmark{Type: "bodymatter", Label: "Tibetan-English", Src: chapters[0].File})
+	}
+	if len(sections) > 0 {
+		markType := "index"
+		if len(chapters) == 0 {
+			markType = "bodymatter"
+		}
+		marks = append(marks, landmark{Type: markType, Label: "English-Tibetan", Src: sections[0].File})
+	}
+	return marks
+}
+
+// navDepth returns the number of levels of a table of contents
+func navDepth(points []navPoint) int {
+	depth := 0
+	for _, p := range points {
+		if d := 1 + navDepth(p.Children); d > depth {
+			depth = d
+		}
+	}
+	return depth
+}
+
+// playOrder numbers NCX navPoints in reading order. A point with the same
+// target as the one before it, such as a letter and its first term, shares
+// its number.
+type playOrder struct {
+	n   int
+	src string
+}
+
+// next returns the playOrder of a point targeting src
+func (o *playOrder) next(src string) int {
+	if o.n == 0 || src != o.src {
+		o.n++
+		o.src = src
+	}
+	return o.n
+}
+
+// writeNCXPoints writes navPoints in NCX form
+func writeNCXPoints(b *strings.Builder, points []navPoint, indent string, order *playOrder) {
+	for _, p := range points {
+		fmt.Fprintf(b, "%s<navPoint id=\"%s\" playOrder=\"%d\">\n", indent, p.ID, order.next(p.Src))
+		fmt.Fprintf(b, "%s  <navLabel><text>%s</text></navLabel>\n", indent, escapeXML(p.Label))
+		fmt.Fprintf(b, "%s  <content src=\"%s\"/>\n", indent, p.Src)
+		writeNCXPoints(b, p.Children, indent+"  ", order)
+		fmt.Fprintf(b, "%s</navPoint>\n", indent)
+	}
+}
+
+// writeNavList writes navPoints as the nested ordered list of a nav document
+func writeNavList(b *strings.Builder, points []navPoint, indent string) {
+	fmt.Fprintf(b, "%s<ol>\n", indent)
+	for _, p := range points {
+		fmt.Fprintf(b, "%s  <li><a href=\"%s\">%s</a>", indent, p.Src, escapeXML(p.Label))
+		if len(p.Children) > 0 {
+			b.WriteString("\n")
+			writeNavList(b, p.Children, indent+"    ")
+			fmt.Fprintf(b, "%s  ", indent)
+		}
+		b.WriteString("</li>\n")
+	}
+	fmt.Fprintf(b, "%s</ol>\n", indent)
+}
+
+// writeNav writes the EPUB 3 navigation document OEBPS/nav.xhtml, with the
+// table of contents and the landmarks
+func (eg *EbookGenerator) writeNav(writer *zip.Writer, points []navPoint, marks []landmark) error {
+	f, err := writer.Create("OEBPS/nav.xhtml")
+	if err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	b.WriteString(eg.xhtmlHead("Table of Contents", ""))
+	b.WriteString("    <nav epub:type=\"toc\" id=\"toc\">\n      <h1>Table of Contents</h1>\n")
+	writeNavList(&b, points, "      ")
+	b.WriteString("    </nav>\n")
+
+	b.WriteString("    <nav epub:type=\"landmarks\" id=\"landmarks\" hidden=\"hidden\">\n      <h2>Landmarks</h2>\n      <ol>\n")
+	for _, m := range marks {
+		fmt.Fprintf(&b, "        <li><a epub:type=\"%s\" href=\"%s\">%s</a></li>\n", m.Type, m.Src, escapeXML(m.Label))
+	}
+	b.WriteString("      </ol>\n    </nav>\n  </body>\n</html>\n")
+
+	_, err = io.WriteString(f, b.String())
+	return err
+}
+
+// writeContentsPage writes OEBPS/toc.xhtml, the table of contents page in
+// the book itself: an index of the root letters of the Tibetan-English
+// volume, or of its chapter files when terms are not in Tibetan order, and
+// of the letters of the English-Tibetan volume
+func (eg *EbookGenerator) writeContentsPage(writer *zip.Writer, chapters []termChapter, sections []englishSection) error {
+	f, err := writer.Create("OEBPS/toc.xhtml")
+	if err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	b.WriteString(eg.xhtmlHead("Contents", "frontmatter"))
+	b.WriteString("    <h1>Contents</h1>\n")
+
+	if eg.sortOrder != orderTibetan && len(chapters) > 0 {
+		b.WriteString("    <h2>Tibetan-English</h2>\n    <ul>\n")
+		for _, chapter := range chapters {
+			fmt.Fprintf(&b, "      <li><a href=\"%s\">%s</a></li>\n", chapter.File, escapeXML(chapter.title()))
+		}
+		b.WriteString("    </ul>\n")
+	} else if letters := termNavPoints(chapters, tocLetters); len(letters) > 0 {
+		b.WriteString("    <h2>Tibetan-English</h2>\n    <p class=\"letter-index\">\n")
+		for _, letter := range letters {
+			fmt.Fprintf(&b, "      <a class=\"unicode\" href=\"%s\">%s</a>\n", letter.Src, escapeXML(letter.Label))
+		}
+		b.WriteString("    </p>\n")
+	}
+
+	if len(sections) > 0 {
+		b.WriteString("    <h2>English-Tibetan</h2>\n    <p class=\"letter-index\">\n")
+		for _, section := range sections {
+			fmt.Fprintf(&b, "      <a href=\"%s\">%s</a>\n", section.File, escapeXML(section.Letter))
+		}
+		b.WriteString("    </p>\n")
+	}
+
+	b.WriteString("  </body>\n</html>\n")
+	_, err = io.WriteString(f, b.String())
+	return err
+}
+
+// xhtmlHead returns the start of an XHTML content document, up to and
+// including the body tag: XHTML 1.1 for EPUB 2, HTML5 for EPUB 3. epubType
+// sets the body's epub:type in EPUB 3. Kindle dictionaries also declare the
+// idx and mbp namespaces.
+func (eg *EbookGenerator) xhtmlHead(title, epubType string) string {
+	if eg.epubVersion != epubVersion3 {
+		namespaces := ""
+		if eg.kindleDict {
+			namespaces = kindleNamespaces
+		}
+		return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.1//EN" "http://www.w3.org/TR/xhtml11/DTD/xhtml11.dtd">
+<html xmlns="http://www.w3.org/1999/xhtml"%s>
+  <head>
+    <title>%s</title>
+    <link rel="stylesheet" type="text/css" href="style.css"/>
+  </head>
+  <body>
+`, namespaces, escapeXML(title))
+	}
+
+	body := "<body>"
+	if epubType != "" {
+		body = fmt.Sprintf(`<body epub:type="%s">`, epubType)
+	}
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+  <head>
+    <meta charset="utf-8"/>
+    <title>%s</title>
+    <link rel="stylesheet" type="text/css" href="style.css"/>
+  </head>
+  %s
+`, escapeXML(title), body)
+}
+
+// footerTag returns the element for a chapter's metadata footer
+func (eg *EbookGenerator) footerTag() string {
+	if eg.epubVersion == epubVersion3 {
+		return "footer"
+	}
+	return "div"
+}
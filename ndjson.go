@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// lineError locates a decoding error on one line of a JSON Lines input
+type lineError struct {
+	Line   int
+	Column int
+	Offset int64 // byte offset of the error from the start of the input
+	Err    error
+}
+
+func (e *lineError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *lineError) Unwrap() error {
+	return e.Err
+}
+
+// ndjsonSource reads JSON Lines files holding one per-term or paged record per line
+type ndjsonSource struct{}
+
+func (ndjsonSource) Name() string         { return formatNDJSON }
+func (ndjsonSource) Extensions() []string { return []string{".jsonl", ".ndjson"} }
+
+// Detect accepts any file with a JSON Lines extension; bad lines are reported by Read
+func (ndjsonSource) Detect(doc *TermDocument) bool {
+	return true
+}
+
+func (ndjsonSource) Read(doc *TermDocument, yield func(TermData) error) error {
+	return readNDJSON(doc.Reader, yield)
+}
+
+// readNDJSON reads one per-term or paged-style record per line, streaming the
+// input so it never holds more than one record in memory. Blank lines are
+// ignored. Bad lines are skipped; the first one is returned as a *lineError
+// once the whole input has been read.
+func readNDJSON(r io.Reader, yield func(TermData) error) error {
+	br := bufio.NewReader(r)
+	badLines := 0
+	var firstErr *lineError
+	var offset int64
+
+	for lineNum := 1; ; lineNum++ {
+		line, readErr := br.ReadBytes('\n')
+		lineStart := offset
+		offset += int64(len(line))
+
+		if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 {
+			term, err := decodeRecord(trimmed)
+			if err == nil {
+				if err := yield(term); err != nil {
+					return err
+				}
+			} else {
+				badLines++
+				if firstErr == nil {
+					firstErr = &lineError{Line: lineNum, Column: 1, Offset: lineStart, Err: err}
+					if errOffset, ok := jsonErrorOffset(err); ok {
+						firstErr.Offset += errOffset
+						_, firstErr.Column = lineColumnAt(bytes.NewReader(line), errOffset)
+					}
+				}
+			}
+		}
+
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return &lineError{Line: lineNum, Offset: offset, Err: readErr}
+		}
+	}
+
+	if firstErr == nil {
+		return nil
+	}
+	if badLines > 1 {
+		return fmt.Errorf("%d bad lines, first at %w", badLines, firstErr)
+	}
+	return firstErr
+}
+
+// decodeRecord parses a single per-term or paged-style record
+func decodeRecord(data []byte) (TermData, error) {
+	term, perTermErr := decodePerTerm(data)
+	if perTermErr == nil {
+		return term, nil
+	}
+	term, pagedErr := decodePaged(data)
+	if pagedErr == nil {
+		return term, nil
+	}
+	return TermData{}, pickDecodeError(perTermErr, pagedErr)
+}
+
+// asLineError unwraps a *lineError from err, if there is one
+func asLineError(err error) (*lineError, bool) {
+	var le *lineError
+	ok := errors.As(err, &le)
+	return le, ok
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// Issue statuses in the ingestion report
+const (
+	issueSkipped = "skipped" // no terms were taken from the file
+	issuePartial = "partial" // some terms were read before an error
+)
+
+// ingestIssue records an input file that was skipped or only partly read
+type ingestIssue struct {
+	File   string `json:"file"`
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+	Offset int64  `json:"offset,omitempty"` // byte offset of a JSON error
+	Line   int    `json:"line,omitempty"`
+	Column int    `json:"column,omitempty"`
+	Terms  int    `json:"terms,omitempty"` // terms recovered from a partial file
+}
+
+// ingestReport summarises what ReadTermFiles did with the input files
+type ingestReport struct {
+	FilesFound    int            `json:"filesFound"`
+	FilesRead     int            `json:"filesRead"`
+	TermsByFormat map[string]int `json:"termsByFormat"`
+	Issues        []ingestIssue  `json:"issues"`
+}
+
+// newIngestReport creates an empty report
+func newIngestReport() *ingestReport {
+	return &ingestReport{
+		TermsByFormat: make(map[string]int),
+		Issues:        []ingestIssue{},
+	}
+}
+
+// record adds the outcome of reading one file or archive member. A file with
+// an error is partial if it still produced terms, and skipped otherwise.
+func (r *ingestReport) record(path, format string, terms int, err error) {
+	r.FilesFound++
+	if format != "" {
+		r.TermsByFormat[format] += terms
+	}
+	if err == nil {
+		r.FilesRead++
+		return
+	}
+
+	issue := ingestIssue{
+		File:   path,
+		Status: issueSkipped,
+		Reason: err.Error(),
+	}
+	if terms > 0 {
+		r.FilesRead++
+		issue.Status = issuePartial
+		issue.Terms = terms
+	}
+
+	if le, ok := asLineError(err); ok {
+		issue.Line, issue.Column, issue.Offset = le.Line, le.Column, le.Offset
+		// The location has its own fields, so keep the reason free of it
+		if le == err {
+			issue.Reason = le.Err.Error()
+		}
+	} else if offset, ok := jsonErrorOffset(err); ok {
+		issue.Offset = offset
+	}
+
+	r.Issues = append(r.Issues, issue)
+}
+
+// jsonErrorOffset extracts the input byte offset from a JSON decoding error
+func jsonErrorOffset(err error) (int64, bool) {
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return syntaxErr.Offset, true
+	}
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return typeErr.Offset, true
+	}
+	return 0, false
+}
+
+// lineColumnAt converts a JSON error offset into a 1-based line and character
+// column. The decoder reports the offset just past the offending byte.
+func lineColumnAt(r io.Reader, offset int64) (int, int) {
+	line, col := 1, 0
+	br := bufio.NewReader(r)
+	for i := int64(0); i < offset; i++ {
+		b, err := br.ReadByte()
+		if err != nil {
+			break
+		}
+		if b == '\n' {
+			line++
+			col = 0
+		} else if b&0xC0 != 0x80 {
+			// Count characters, not UTF-8 continuation bytes
+			col++
+		}
+	}
+	if col == 0 {
+		col = 1
+	}
+	return line, col
+}
+
+// Print writes a human-readable summary of the report
+func (r *ingestReport) Print(w io.Writer) {
+	fmt.Fprintln(w, "\n📋 Ingestion report")
+	fmt.Fprintf(w, "   Files found: %d, read: %d, with problems: %d\n", r.FilesFound, r.FilesRead, len(r.Issues))
+	if summary := formatSummary(r.TermsByFormat); summary != "" {
+		fmt.Fprintf(w, "   Terms by format: %s\n", summary)
+	}
+
+	for _, issue := range r.Issues {
+		location := ""
+		if issue.Line > 0 {
+			location = fmt.Sprintf(" (line %d, column %d, offset %d)", issue.Line, issue.Column, issue.Offset)
+		}
+		if issue.Status == issuePartial {
+			fmt.Fprintf(w, "   ⚠️  partial: %s%s: %s [%d terms kept]\n", issue.File, location, issue.Reason, issue.Terms)
+		} else {
+			fmt.Fprintf(w, "   ⚠️  skipped: %s%s: %s\n", issue.File, location, issue.Reason)
+		}
+	}
+}
+
+// WriteJSON saves the report as indented JSON
+func (r *ingestReport) WriteJSON(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Editions for -edition
+const (
+	editionTibetan = "tibetan" // Tibetan-English, one chapter per term
+	editionEnglish = "english" // English-Tibetan, built from the definitions
+	editionBoth    = "both"    // both volumes in one book, linked
+)
+
+// validateEdition checks an -edition value
+func validateEdition(edition string) error {
+	switch edition {
+	case editionTibetan, editionEnglish, editionBoth:
+		return nil
+	}
+	return fmt.Errorf("unknown edition %q (want %s, %s or %s)", edition, editionTibetan, editionEnglish, editionBoth)
+}
+
+// ReverseEntry is one English headword of the English-Tibetan volume
+type ReverseEntry struct {
+	Keyword string
+	Terms   []ReverseTerm
+}
+
+// ReverseTerm is a Tibetan term listed under an English headword
+type ReverseTerm struct {
+	Unicode string
+	Wylie   string
+	Sources []string // dictionary sources whose definition gave the keyword
+	Link    string   // the term's entry in the same book, "" if not linked
+}
+
+// englishSection is one chapter file of the English-Tibetan volume: the
+// entries whose keyword starts with the same letter
+type englishSection struct {
+	Letter  string
+	File    string
+	Entries []ReverseEntry
+}
+
+// englishStopWords are dropped from the start of a keyword, and keywords made
+// only of them are skipped
+var englishStopWords = map[string]bool{
+	"a": true, "an": true, "the": true, "to": true, "of": true, "or": true,
+	"and": true, "in": true, "on": true, "at": true, "by": true, "for": true,
+	"with": true, "from": true, "as": true, "is": true, "are": true, "be": true,
+	"been": true, "was": true, "were": true, "it": true, "its": true, "this": true,
+	"that": true, "which": true, "who": true, "etc": true, "e.g.": true, "i.e.": true,
+	"cf": true, "cf.": true, "see": true, "lit": true, "lit.": true, "also": true,
+	"esp": true, "esp.": true, "sth": true, "sb": true, "one's": true, "oneself": true,
+}
+
+// maxKeywordWords is the longest gloss used as an English headword; longer
+// ones are explanations rather than equivalents
+const maxKeywordWords = 4
+
+var (
+	parenthesised = regexp.MustCompile(`\([^)]*\)|\[[^\]]*\]`)
+	wordSplitter  = regexp.MustCompile(`\s+`)
+)
+
+// extractKeywords returns the English keywords of a definition: its glosses
+// split on ";" and ",", lower-cased, without bracketed notes, leading
+// stop-words or trailing punctuation
+func extractKeywords(def string) []string {
+	def = parenthesised.ReplaceAllString(formatDefinitionText(def), " ")
+
+	var keywords []string
+	seen := make(map[string]bool)
+	for _, gloss := range strings.FieldsFunc(def, func(r rune) bool { return r == ';' || r == ',' || r == '\n' }) {
+		if isTibetanScript(gloss) {
+			continue
+		}
+		words := wordSplitter.Split(strings.ToLower(strings.TrimSpace(gloss)), -1)
+		for len(words) > 0 && englishStopWords[strings.Trim(words[0], ".:!?\"'")] {
+			words = words[1:]
+		}
+		if len(words) == 0 || len(words) > maxKeywordWords {
+			continue
+		}
+
+		keyword := strings.TrimFunc(strings.Join(words, " "), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		if len([]rune(keyword)) < 2 || englishStopWords[keyword] || !strings.ContainsFunc(keyword, unicode.IsLetter) || seen[keyword] {
+			continue
+		}
+		seen[keyword] = true
+		keywords = append(keywords, keyword)
+	}
+	return keywords
+}
+
+// buildReverseIndex inverts terms into English entries. Entries are sorted by
+// keyword; the terms under each keep the order of terms, and their sources
+// follow sources. links, if given, holds the link to each term's entry.
+func buildReverseIndex(terms []TermData, sources sourceOrder, links []string) []ReverseEntry {
+	index := make(map[string]*ReverseEntry)
+	var keywords []string
+
+	for i, term := range terms {
+		positions := make(map[string]int) // keyword -> position in its entry's Terms
+		for _, source := range sources.sources(term.Definitions) {
+			for _, keyword := range extractKeywords(englishDefinition(term, source)) {
+				entry, ok := index[keyword]
+				if !ok {
+					entry = &ReverseEntry{Keyword: keyword}
+					index[keyword] = entry
+					keywords = append(keywords, keyword)
+				}
+				if pos, ok := positions[keyword]; ok {
+					entry.Terms[pos].Sources = append(entry.Terms[pos].Sources, source)
+					continue
+				}
+				rt := ReverseTerm{Unicode: term.SearchTerm, Wylie: term.SearchTermWylie, Sources: []string{source}}
+				if i < len(links) {
+					rt.Link = links[i]
+				}
+				positions[keyword] = len(entry.Terms)
+				entry.Terms = append(entry.Terms, rt)
+			}
+		}
+	}
+
+	sort.Slice(keywords, func(i, j int) bool { return keywords[i] < keywords[j] })
+	entries := make([]ReverseEntry, len(keywords))
+	for i, keyword := range keywords {
+		entries[i] = *index[keyword]
+	}
+	return entries
+}
+
+// englishSections groups sorted entries into one chapter file per initial
+// letter; keywords starting with anything else share a "#" section
+func englishSections(entries []ReverseEntry) []englishSection {
+	var sections []englishSection
+	for _, entry := range entries {
+		letter := "#"
+		if first := []rune(entry.Keyword)[0]; unicode.IsLetter(first) {
+			letter = strings.ToUpper(string(first))
+		}
+		if n := len(sections); n == 0 || sections[n-1].Letter != letter {
+			sections = append(sections, englishSection{
+				Letter: letter,
+				File:   fmt.Sprintf("english%d.xhtml", len(sections)+1),
+			})
+		}
+		sections[len(sections)-1].Entries = append(sections[len(sections)-1].Entries, entry)
+	}
+	return sections
+}
+
+// writeEnglishChapters writes one XHTML file per section of the English-Tibetan volume
+func (eg *EbookGenerator) writeEnglishChapters(writer *zip.Writer, sections []englishSection) error {
+	for _, section := range sections {
+		f, err := writer.Create("OEBPS/" + section.File)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, eg.formatEnglishSection(section)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// formatEnglishSection formats one letter of the English-Tibetan volume as XHTML
+func (eg *EbookGenerator) formatEnglishSection(section englishSection) string {
+	var b strings.Builder
+	b.WriteString(eg.xhtmlHead(section.Letter, "index"))
+	if eg.kindleDict {
+		b.WriteString("    <mbp:frameset>\n")
+	}
+	fmt.Fprintf(&b, "    <h1 class=\"letter\">%s</h1>\n", escapeXML(section.Letter))
+
+	for _, entry := range section.Entries {
+		var e strings.Builder
+		keyword := escapeXML(entry.Keyword)
+		if eg.kindleDict {
+			keyword = kindleOrth(entry.Keyword, keyword, nil)
+		}
+		fmt.Fprintf(&e, `      <h2 class="keyword">%s</h2>
+      <ul>
+`, keyword)
+		for _, rt := range entry.Terms {
+			term := formatHeadword(rt.Unicode, rt.Wylie, false, false)
+			if rt.Link != "" {
+				term = fmt.Sprintf(`<a href="%s">%s</a>`, rt.Link, term)
+			}
+			fmt.Fprintf(&e, `        <li>%s <span class="dict-name">%s</span></li>
+`, term, escapeXML(strings.Join(rt.Sources, ", ")))
+		}
+		e.WriteString("      </ul>\n")
+
+		body := e.String()
+		if eg.kindleDict {
+			body = kindleEntry(kindleIndexEnglish, body)
+		}
+		fmt.Fprintf(&b, "    <div class=\"reverse-entry\">\n%s    </div>\n", body)
+	}
+
+	if eg.kindleDict {
+		b.WriteString("    </mbp:frameset>\n")
+	}
+	b.WriteString("  </body>\n</html>\n")
+	return b.String()
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// TermSource decodes one input format into TermData values. Formats are
+// registered with RegisterTermSource; ReadTermFiles offers every input
+// document to the sources whose extensions match its name, and the first
+// source whose Detect accepts it reads it.
+type TermSource interface {
+	// Name identifies the format in the ingestion report, e.g. "per-term"
+	Name() string
+
+	// Extensions lists the lower-case file name suffixes the format uses,
+	// e.g. ".json". Input discovery only picks up files with these suffixes.
+	Extensions() []string
+
+	// Detect reports whether the document is in this format, usually by
+	// sniffing doc.Head. It must not read from doc.Reader.
+	Detect(doc *TermDocument) bool
+
+	// Read decodes the document, calling yield once per term. Terms yielded
+	// before an error are kept and the file is reported as partly read.
+	Read(doc *TermDocument, yield func(TermData) error) error
+}
+
+// TermDocument is a single input document offered to the term sources: a
+// plain file, a gzipped file, an archive member or standard input
+type TermDocument struct {
+	Name   string    // file or member name, without any .gz suffix
+	Path   string    // path on disk when the document is a plain file, else ""
+	Head   []byte    // up to sniffSize bytes from the start of the content
+	Reader io.Reader // the full content, including Head
+
+	reopen func() (io.ReadCloser, error) // opens the content again, nil for streams
+	keys   map[string]byte               // top-level keys, see documentKeys
+}
+
+// sniffSize is how much of each document is made available in TermDocument.Head
+const sniffSize = 64 * 1024
+
+// termSources holds the registered formats in the order they are tried
+var termSources = []TermSource{
+	perTermSource{},
+	pagedSource{},
+	aggregatedSource{},
+	ndjsonSource{},
+	defaultCSVSource,
+	stardictSource{},
+}
+
+// builtinSourceCount is how many entries of termSources are built in
+var builtinSourceCount = len(termSources)
+
+// RegisterTermSource adds an input format. Registered formats are tried
+// before the built-in ones, in registration order, so they can claim files
+// the built-in formats would otherwise read. Call it from an init function.
+func RegisterTermSource(src TermSource) {
+	custom := len(termSources) - builtinSourceCount
+	termSources = append(termSources, nil)
+	copy(termSources[custom+1:], termSources[custom:])
+	termSources[custom] = src
+}
+
+// sourceNames lists the names of the registered formats, e.g. "per-term, paged and csv"
+func sourceNames() string {
+	names := make([]string, len(termSources))
+	for i, src := range termSources {
+		names[i] = src.Name()
+	}
+	if len(names) == 1 {
+		return names[0]
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " and " + names[len(names)-1]
+}
+
+// sourceMatchesName reports whether a file name has one of the source's extensions
+func sourceMatchesName(src TermSource, name string) bool {
+	return hasAnySuffix(name, src.Extensions()...)
+}
+
+// isTermFile reports whether any registered format reads files with this name
+func isTermFile(name string) bool {
+	for _, src := range termSources {
+		if sourceMatchesName(src, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// readTermFile reads one input file, which may be a document in any
+// registered format, gzipped or not, or a zip/tar archive of those, and
+// records the outcome of every document in report.
+func readTermFile(path string, set *termSet, report *ingestReport) {
+	if path == stdinInput {
+		readStdin(set, report)
+		return
+	}
+	if isArchiveFile(path) {
+		readArchive(path, set, report)
+		return
+	}
+
+	open := func() (io.ReadCloser, error) { return os.Open(path) }
+	name := path
+	diskPath := path
+	if isGzipFile(path) {
+		open = func() (io.ReadCloser, error) { return openGzipFile(path) }
+		name = strings.TrimSuffix(path, filepath.Ext(path))
+		diskPath = ""
+	}
+
+	readTermDocument(path, name, diskPath, open, set, report)
+}
+
+// readTermDocument opens one document, decodes it into set and records the
+// result under label. open is also used to re-read the document to locate a
+// JSON error by line and column.
+func readTermDocument(label, name, path string, open func() (io.ReadCloser, error), set *termSet, report *ingestReport) {
+	r, err := open()
+	if err != nil {
+		report.record(label, "", 0, err)
+		return
+	}
+	format, n, err := readTermStream(name, path, open, r, set)
+	r.Close()
+
+	report.record(label, format, n, locateJSONError(err, open))
+}
+
+// readTermStream offers a document to the registered sources and adds the
+// terms of the first one that detects it to set. reopen, if not nil, opens
+// the document again for sources that need to look past its head. It
+// returns the format name and term count.
+func readTermStream(name, path string, reopen func() (io.ReadCloser, error), r io.Reader, set *termSet) (string, int, error) {
+	br := bufio.NewReaderSize(r, sniffSize)
+	head, _ := br.Peek(sniffSize)
+	doc := &TermDocument{Name: name, Path: path, Head: head, Reader: br, reopen: reopen}
+
+	count := 0
+	yield := func(term TermData) error {
+		set.add(term)
+		count++
+		return nil
+	}
+
+	var tried []string
+	for _, src := range termSources {
+		if !sourceMatchesName(src, name) {
+			continue
+		}
+		tried = append(tried, src.Name())
+		if src.Detect(doc) {
+			err := src.Read(doc, yield)
+			return src.Name(), count, err
+		}
+	}
+
+	return "", 0, unrecognisedDocumentError(name, br, tried)
+}
+
+// unrecognisedDocumentError explains why no source accepted a document. For
+// JSON files a syntax error is the likeliest cause, so that is checked first.
+func unrecognisedDocumentError(name string, r io.Reader, tried []string) error {
+	if hasAnySuffix(name, ".json") {
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		var v interface{}
+		if err := json.Unmarshal(data, &v); err != nil {
+			return err
+		}
+	}
+	if len(tried) == 0 {
+		return fmt.Errorf("no input format reads files named like %s", name)
+	}
+	return fmt.Errorf("not a recognised term export (tried %s)", strings.Join(tried, ", "))
+}
+
+// locateJSONError turns a JSON error carrying a byte offset into a *lineError
+// by re-reading the document. Errors that are already located, carry no
+// offset, or whose document cannot be re-read are returned unchanged.
+func locateJSONError(err error, open func() (io.ReadCloser, error)) error {
+	if err == nil || open == nil {
+		return err
+	}
+	if _, ok := asLineError(err); ok {
+		return err
+	}
+	offset, ok := jsonErrorOffset(err)
+	if !ok {
+		return err
+	}
+
+	r, openErr := open()
+	if openErr != nil {
+		return err
+	}
+	defer r.Close()
+
+	line, col := lineColumnAt(r, offset)
+	return &lineError{Line: line, Column: col, Offset: offset, Err: err}
+}
+
+// formatSummary renders per-format term counts, e.g. "per-term 12, aggregated 20000"
+func formatSummary(counts map[string]int) string {
+	var parts []string
+	for _, src := range termSources {
+		if n := counts[src.Name()]; n > 0 {
+			parts = append(parts, fmt.Sprintf("%s %d", src.Name(), n))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
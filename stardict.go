@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const formatStarDict = "stardict"
+
+// stardictMagic is the first line of every StarDict .ifo file
+const stardictMagic = "StarDict's dict ifo file"
+
+// stardictSource reads a local StarDict dictionary. The .ifo file is the
+// input; the .idx, .dict (or .dict.dz) and optional .syn files next to it are
+// opened from disk. The bookname becomes the dictionary source of every
+// definition and synonyms become related terms.
+type stardictSource struct{}
+
+func (stardictSource) Name() string         { return formatStarDict }
+func (stardictSource) Extensions() []string { return []string{".ifo"} }
+
+func (stardictSource) Detect(doc *TermDocument) bool {
+	return bytes.HasPrefix(bytes.TrimLeft(doc.Head, "\ufeff"), []byte(stardictMagic))
+}
+
+// stardictInfo holds the .ifo fields the reader needs
+type stardictInfo struct {
+	bookName         string
+	idxOffsetBits    int
+	sameTypeSequence string
+}
+
+// stardictEntry is one .idx record: a headword and where its data is in .dict
+type stardictEntry struct {
+	word   string
+	offset uint64
+	size   uint32
+}
+
+func (stardictSource) Read(doc *TermDocument, yield func(TermData) error) error {
+	if doc.Path == "" {
+		return errors.New("StarDict dictionaries can only be read from files on disk, not archives or stdin")
+	}
+
+	info, err := parseStarDictInfo(doc.Reader)
+	if err != nil {
+		return err
+	}
+	base := doc.Path[:len(doc.Path)-len(".ifo")]
+
+	entries, err := readStarDictIndex(base, info.idxOffsetBits)
+	if err != nil {
+		return err
+	}
+	dict, err := openStarDictData(base)
+	if err != nil {
+		return err
+	}
+	defer dict.Close()
+
+	terms := newTermSet(mergeJoin)
+	for _, entry := range entries {
+		data := make([]byte, entry.size)
+		if _, err := dict.ReadAt(data, int64(entry.offset)); err != nil {
+			return fmt.Errorf("reading definition of %q: %w", entry.word, err)
+		}
+		terms.add(starDictTerm(entry.word, info.bookName, starDictText(data, info.sameTypeSequence)))
+	}
+
+	if err := addStarDictSynonyms(base, entries, terms); err != nil {
+		yieldAll(terms.terms, yield)
+		return err
+	}
+
+	return yieldAll(terms.terms, yield)
+}
+
+// parseStarDictInfo reads the key=value lines of an .ifo file
+func parseStarDictInfo(r io.Reader) (stardictInfo, error) {
+	info := stardictInfo{idxOffsetBits: 32}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "bookname":
+			info.bookName = value
+		case "idxoffsetbits":
+			if bits, err := strconv.Atoi(value); err == nil && bits == 64 {
+				info.idxOffsetBits = 64
+			}
+		case "sametypesequence":
+			info.sameTypeSequence = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return info, err
+	}
+	if info.bookName == "" {
+		info.bookName = "StarDict"
+	}
+	return info, nil
+}
+
+// openStarDictFile opens base+ext, or its gzipped form base+ext+".gz"/".dz"
+func openStarDictFile(base, ext string) ([]byte, error) {
+	if data, err := ioutil.ReadFile(base + ext); err == nil {
+		return data, nil
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	for _, zext := range []string{".gz", ".dz"} {
+		f, err := os.Open(base + ext + zext)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, fmt.Errorf("%s%s%s: %w", base, ext, zext, err)
+		}
+		return ioutil.ReadAll(gz)
+	}
+
+	return nil, os.ErrNotExist
+}
+
+// readStarDictIndex parses the .idx file into entries in index order
+func readStarDictIndex(base string, offsetBits int) ([]stardictEntry, error) {
+	data, err := openStarDictFile(base, ".idx")
+	if err != nil {
+		return nil, fmt.Errorf("StarDict index %s.idx: %w", base, err)
+	}
+
+	offsetSize := offsetBits / 8
+	var entries []stardictEntry
+	for len(data) > 0 {
+		end := bytes.IndexByte(data, 0)
+		if end < 0 || len(data) < end+1+offsetSize+4 {
+			return entries, fmt.Errorf("StarDict index %s.idx is truncated after %d entries", base, len(entries))
+		}
+		entry := stardictEntry{word: string(data[:end])}
+		data = data[end+1:]
+		if offsetSize == 8 {
+			entry.offset = binary.BigEndian.Uint64(data)
+		} else {
+			entry.offset = uint64(binary.BigEndian.Uint32(data))
+		}
+		entry.size = binary.BigEndian.Uint32(data[offsetSize:])
+		data = data[offsetSize+4:]
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// stardictData gives random access to definition data
+type stardictData interface {
+	io.ReaderAt
+	io.Closer
+}
+
+// openStarDictData opens the .dict file for random access. A compressed
+// .dict.dz is decompressed into memory.
+func openStarDictData(base string) (stardictData, error) {
+	f, err := os.Open(base + ".dict")
+	if err == nil {
+		return f, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	data, err := openStarDictFile(base, ".dict")
+	if err != nil {
+		return nil, fmt.Errorf("StarDict data %s.dict: %w", base, err)
+	}
+	return nopCloserReaderAt{bytes.NewReader(data)}, nil
+}
+
+type nopCloserReaderAt struct {
+	*bytes.Reader
+}
+
+func (nopCloserReaderAt) Close() error { return nil }
+
+// addStarDictSynonyms reads the optional .syn file and adds each synonym as a
+// related term of the entry it points to
+func addStarDictSynonyms(base string, entries []stardictEntry, terms *termSet) error {
+	data, err := openStarDictFile(base, ".syn")
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("StarDict synonyms %s.syn: %w", base, err)
+	}
+
+	for len(data) > 0 {
+		end := bytes.IndexByte(data, 0)
+		if end < 0 || len(data) < end+5 {
+			return fmt.Errorf("StarDict synonyms %s.syn is truncated", base)
+		}
+		synonym := string(data[:end])
+		index := binary.BigEndian.Uint32(data[end+1:])
+		data = data[end+5:]
+
+		if int(index) >= len(entries) {
+			continue
+		}
+		terms.add(TermData{
+			SearchTerm:      starDictHeadword(entries[index].word, true),
+			SearchTermWylie: starDictHeadword(entries[index].word, false),
+			RelatedTerms:    []RelatedTerm{relatedTermFor(synonym)},
+		})
+	}
+	return nil
+}
+
+// starDictTerm builds the TermData for one dictionary entry
+func starDictTerm(word, bookName, text string) TermData {
+	term := TermData{
+		SearchTerm:      starDictHeadword(word, true),
+		SearchTermWylie: starDictHeadword(word, false),
+		Definitions:     map[string]string{},
+	}
+	if text != "" {
+		term.Definitions[bookName] = text
+	}
+	term.DefinitionsCount = len(term.Definitions)
+	return term
+}
+
+// starDictHeadword returns word if its script matches the wanted form
+// (Tibetan script for unicode, anything else for Wylie), else ""
+func starDictHeadword(word string, unicode bool) string {
+	if isTibetanScript(word) == unicode {
+		return word
+	}
+	return ""
+}
+
+// starDictText extracts the readable text from an entry's data. With a
+// sametypesequence the type markers are implied; otherwise each field starts
+// with its type letter. Lower-case types are text, upper-case ones binary.
+func starDictText(data []byte, sameTypeSequence string) string {
+	var parts []string
+	addField := func(kind byte, field []byte) {
+		if text := starDictFieldText(kind, field); text != "" {
+			parts = append(parts, text)
+		}
+	}
+
+	if sameTypeSequence != "" {
+		for i := 0; i < len(sameTypeSequence) && len(data) > 0; i++ {
+			kind := sameTypeSequence[i]
+			last := i == len(sameTypeSequence)-1
+			field, rest := splitStarDictField(kind, data, last)
+			addField(kind, field)
+			data = rest
+		}
+	} else {
+		for len(data) > 0 {
+			kind := data[0]
+			field, rest := splitStarDictField(kind, data[1:], false)
+			addField(kind, field)
+			data = rest
+		}
+	}
+
+	return strings.Join(parts, "; ")
+}
+
+// splitStarDictField cuts one field off data. The last field of a
+// sametypesequence entry has no terminator or size prefix.
+func splitStarDictField(kind byte, data []byte, last bool) ([]byte, []byte) {
+	if last {
+		return data, nil
+	}
+	if kind >= 'a' && kind <= 'z' {
+		if end := bytes.IndexByte(data, 0); end >= 0 {
+			return data[:end], data[end+1:]
+		}
+		return data, nil
+	}
+	if len(data) < 4 {
+		return nil, nil
+	}
+	size := int(binary.BigEndian.Uint32(data))
+	data = data[4:]
+	if size > len(data) {
+		size = len(data)
+	}
+	return data[:size], data[size:]
+}
+
+// stardictBlockTags and stardictMarkup match the block-level and all other
+// tags of HTML, Pango and XDXF fields
+var (
+	stardictBlockTags = regexp.MustCompile(`(?i)<(br|/?p|/?div|/?li|/?tr|/?def)\b[^>]*>`)
+	stardictMarkup    = regexp.MustCompile(`<[^>]*>`)
+)
+
+// starDictFieldText returns the plain text of a textual field
+func starDictFieldText(kind byte, field []byte) string {
+	switch kind {
+	case 'm', 'l', 't', 'y':
+		return strings.TrimSpace(string(field))
+	case 'g', 'h', 'x':
+		text := stardictBlockTags.ReplaceAllString(string(field), " ")
+		text = html.UnescapeString(stardictMarkup.ReplaceAllString(text, ""))
+		return strings.Join(strings.Fields(text), " ")
+	}
+	// Images, sounds and other binary fields have no text
+	return ""
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+)
+
+// stdinInput is the -input value that reads terms from standard input
+const stdinInput = "-"
+
+// stdinLabel names standard input in the ingestion report
+const stdinLabel = "<stdin>"
+
+// readStdin reads terms piped in on standard input
+func readStdin(set *termSet, report *ingestReport) {
+	format, n, err := readPipedTerms(os.Stdin, set)
+	report.record(stdinLabel, format, n, err)
+}
+
+// readPipedTerms reads a stream that is either JSON Lines or a single JSON
+// export. It cannot be re-read, so the format is decided from its start: an
+// aggregated export is streamed, a first line that is a complete JSON value
+// means JSON Lines, and anything else is decoded as one document.
+func readPipedTerms(r io.Reader, set *termSet) (string, int, error) {
+	br := bufio.NewReaderSize(r, sniffSize)
+	head, _ := br.Peek(sniffSize)
+
+	name := "stdin.json"
+	if agg, _ := isAggregatedExport(bytes.NewReader(head)); !agg && firstLineIsJSON(head) {
+		name = "stdin.jsonl"
+	}
+	return readTermStream(name, "", nil, br, set)
+}
+
+// firstLineIsJSON reports whether the first non-blank line of head is a
+// complete JSON value on its own, as it is in JSON Lines input
+func firstLineIsJSON(head []byte) bool {
+	for len(head) > 0 {
+		line := head
+		rest := []byte(nil)
+		if i := bytes.IndexByte(head, '\n'); i >= 0 {
+			line, rest = head[:i], head[i+1:]
+		} else if len(head) == sniffSize {
+			// The first line runs past the peeked prefix; assume one long record per line
+			return true
+		}
+
+		if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 {
+			return json.Valid(trimmed)
+		}
+		head = rest
+	}
+	return false
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// errStopWalk ends a top-level walk early without reporting an error
+var errStopWalk = errors.New("stop walk")
+
+// errNoTermsObject is returned for a document read as an aggregated export
+// that has no top-level "terms" object
+var errNoTermsObject = errors.New(`no top-level "terms" object; not an aggregated export`)
+
+// walkTopLevelObject reads a JSON object from dec one key at a time. onKey is
+// called with the decoder positioned at the key's value and must consume it;
+// if it returns false the value is skipped instead.
+func walkTopLevelObject(dec *json.Decoder, onKey func(key string) (bool, error)) error {
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key, ok := tok.(string)
+		if !ok {
+			return fmt.Errorf("expected object key, got %v", tok)
+		}
+
+		handled, err := onKey(key)
+		if err != nil {
+			return err
+		}
+		if !handled {
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return err
+			}
+		}
+	}
+
+	return expectDelim(dec, '}')
+}
+
+// expectDelim reads the next token and checks that it is the given delimiter
+func expectDelim(dec *json.Decoder, want json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if d, ok := tok.(json.Delim); !ok || d != want {
+		return fmt.Errorf("expected %q, got %v", want, tok)
+	}
+	return nil
+}
+
+// isAggregatedExport reports whether the document has a top-level "terms"
+// object. It stops reading as soon as that key is found.
+func isAggregatedExport(r io.Reader) (bool, error) {
+	dec := json.NewDecoder(r)
+	found := false
+
+	err := walkTopLevelObject(dec, func(key string) (bool, error) {
+		if key != "terms" {
+			return false, nil
+		}
+		tok, err := dec.Token()
+		if err != nil {
+			return false, err
+		}
+		if d, ok := tok.(json.Delim); ok && d == '{' {
+			found = true
+		}
+		return false, errStopWalk
+	})
+	if err == errStopWalk {
+		err = nil
+	}
+	return found, err
+}
+
+// scanTopLevelKeys opens a document again and reads its top-level keys up to
+// the first searchTerm or terms key, returning the first byte of that key's
+// value as sniffJSONObject does. Earlier values are skipped one at a time.
+func scanTopLevelKeys(open func() (io.ReadCloser, error)) (map[string]byte, error) {
+	r, err := open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	dec := json.NewDecoder(r)
+	keys := make(map[string]byte)
+	err = walkTopLevelObject(dec, func(key string) (bool, error) {
+		if key != "searchTerm" && key != "terms" {
+			return false, nil
+		}
+		tok, err := dec.Token()
+		if err != nil {
+			return false, err
+		}
+		switch v := tok.(type) {
+		case json.Delim:
+			keys[key] = byte(v)
+		case string:
+			keys[key] = '"'
+		default:
+			keys[key] = 0
+		}
+		return false, errStopWalk
+	})
+	if err == errStopWalk {
+		err = nil
+	}
+	return keys, err
+}
+
+// streamAggregatedTerms walks the "terms" object of a single-mode export token
+// by token and hands each TermData to fn as soon as it is decoded, so a full
+// dictionary export never has to be held in memory at once. A document
+// without a "terms" object is an error, not an empty export.
+func streamAggregatedTerms(r io.Reader, fn func(TermData) error) (int, error) {
+	dec := json.NewDecoder(r)
+	count := 0
+	found := false
+
+	err := walkTopLevelObject(dec, func(key string) (bool, error) {
+		if key != "terms" {
+			return false, nil
+		}
+		found = true
+		if err := expectDelim(dec, '{'); err != nil {
+			return false, err
+		}
+
+		for dec.More() {
+			tok, err := dec.Token()
+			if err != nil {
+				return false, err
+			}
+			termKey, _ := tok.(string)
+
+			var term TermData
+			if err := dec.Decode(&term); err != nil {
+				return false, fmt.Errorf("term %q: %w", termKey, err)
+			}
+			// Use the key as searchTerm if not already set
+			if term.SearchTerm == "" {
+				term.SearchTerm = termKey
+			}
+			fillDefinitionText(&term)
+			if err := fn(term); err != nil {
+				return false, err
+			}
+			count++
+		}
+
+		return true, expectDelim(dec, '}')
+	})
+	if err == nil && !found {
+		err = errNoTermsObject
+	}
+
+	return count, err
+}